@@ -0,0 +1,178 @@
+package album
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/frizinak/binary"
+)
+
+const Ext = ".album"
+
+var version = []byte{'A', 0}
+
+// Album is an ordered list of raw checksums.
+type Album struct {
+	Name  string
+	Title string
+	Cover string
+	Items []string
+}
+
+func New(name string) Album { return Album{Name: name, Title: name, Items: []string{}} }
+
+func ValidName(name string) error {
+	if name == "" || name[0] == '.' || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid album name '%s'", name)
+	}
+	return nil
+}
+
+func Path(dir, name string) string { return filepath.Join(dir, name+Ext) }
+
+func (a Album) Index(sum string) int {
+	for i, s := range a.Items {
+		if s == sum {
+			return i
+		}
+	}
+	return -1
+}
+
+func (a Album) Contains(sum string) bool { return a.Index(sum) != -1 }
+
+// Add appends the given checksums that are not yet part of the album.
+func (a *Album) Add(sums ...string) int {
+	n := 0
+	for _, s := range sums {
+		if !a.Contains(s) {
+			a.Items = append(a.Items, s)
+			n++
+		}
+	}
+	return n
+}
+
+func (a *Album) Remove(sums ...string) int {
+	rm := make(map[string]struct{}, len(sums))
+	for _, s := range sums {
+		rm[s] = struct{}{}
+	}
+	items := make([]string, 0, len(a.Items))
+	for _, s := range a.Items {
+		if _, ok := rm[s]; !ok {
+			items = append(items, s)
+		}
+	}
+	n := len(a.Items) - len(items)
+	a.Items = items
+	if _, ok := rm[a.Cover]; ok {
+		a.Cover = ""
+	}
+	return n
+}
+
+// CoverItem returns the cover checksum, the first item if none was set.
+func (a Album) CoverItem() string {
+	if a.Cover != "" && a.Contains(a.Cover) {
+		return a.Cover
+	}
+	if len(a.Items) != 0 {
+		return a.Items[0]
+	}
+	return ""
+}
+
+func (a Album) encode(w *binary.Writer) {
+	w.WriteString(a.Name, 16)
+	w.WriteString(a.Title, 16)
+	w.WriteString(a.Cover, 8)
+	w.WriteUint32(uint32(len(a.Items)))
+	for _, s := range a.Items {
+		w.WriteString(s, 8)
+	}
+}
+
+func (a Album) decode(r *binary.Reader) Album {
+	a.Name = r.ReadString(16)
+	a.Title = r.ReadString(16)
+	a.Cover = r.ReadString(8)
+	n := int(r.ReadUint32())
+	a.Items = make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		a.Items = append(a.Items, r.ReadString(8))
+	}
+	return a
+}
+
+func Load(path string) (Album, error) {
+	var a Album
+	d, err := os.ReadFile(path)
+	if err != nil {
+		return a, err
+	}
+	if len(d) < len(version) || !bytes.Equal(d[:len(version)], version) {
+		return a, fmt.Errorf("invalid album file '%s'", path)
+	}
+
+	r := binary.NewReader(bytes.NewReader(d[len(version):]))
+	a = a.decode(r)
+	if err := r.Err(); err != nil {
+		return a, fmt.Errorf("could not load album %s: %w", path, err)
+	}
+	return a, nil
+}
+
+func (a Album) Save(path string) error {
+	if err := ValidName(a.Name); err != nil {
+		return err
+	}
+	buf := bytes.NewBuffer(nil)
+	buf.Write(version)
+	w := binary.NewWriter(buf)
+	a.encode(w)
+	if err := w.Err(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// List loads all albums in dir sorted by name.
+func List(dir string) ([]Album, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	l := make([]Album, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != Ext {
+			continue
+		}
+		a, err := Load(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return l, err
+		}
+		l = append(l, a)
+	}
+
+	sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	return l, nil
+}
@@ -0,0 +1,373 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/frizinak/photos/cmd/flags"
+)
+
+// LibraryConfigFile is read from -base and contains flag defaults, named
+// action pipelines and filter presets for that library, e.g.:
+//
+//	sizes   = [3840, 1920]
+//	workers = 8
+//	editor  = "gimp"
+//
+//	[pipelines]
+//	daily = "import,link,sync-meta,link,previews"
+//
+//	[queries]
+//	keepers = "rating>=3 and not deleted"
+const LibraryConfigFile = "photos.toml"
+
+const (
+	confSectionPipelines = "pipelines"
+	confSectionQueries   = "queries"
+)
+
+type confArg struct {
+	name  string
+	value string
+	set   bool
+}
+
+// Pipelines maps pipeline names to their actions.
+type Pipelines map[string][]string
+
+func (p Pipelines) Names() []string {
+	l := make([]string, 0, len(p))
+	for n := range p {
+		l = append(l, n)
+	}
+	sort.Strings(l)
+	return l
+}
+
+type libConf struct {
+	args      []confArg
+	pipelines Pipelines
+	queries   Queries
+}
+
+// loadGlobalConf reads a file of '-flag value' lines.
+func loadGlobalConf(file string) ([]confArg, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	args := make([]confArg, 0)
+	s := bufio.NewScanner(f)
+	s.Split(bufio.ScanLines)
+	for s.Scan() {
+		t := strings.TrimSpace(s.Text())
+		if t == "" || t[0] == '#' {
+			continue
+		}
+		p := strings.SplitN(t, " ", 2)
+		a := confArg{name: strings.TrimLeft(p[0], "-")}
+		if len(p) == 2 {
+			a.value, a.set = strings.TrimSpace(p[1]), true
+		}
+		args = append(args, a)
+	}
+
+	return args, s.Err()
+}
+
+// loadLibConf parses the subset of toml we need: comments, [sections],
+// key = value pairs with strings, integers, booleans and (multiline) arrays
+// thereof.
+func loadLibConf(file string) (libConf, error) {
+	c := libConf{pipelines: make(Pipelines), queries: make(Queries)}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Split(bufio.ScanLines)
+	section := ""
+	lineno := 0
+	errf := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%s:%d: %s", file, lineno, fmt.Sprintf(format, args...))
+	}
+	seen := make(map[string]struct{})
+
+	for s.Scan() {
+		lineno++
+		t := strings.TrimSpace(stripConfComment(s.Text()))
+		if t == "" {
+			continue
+		}
+		if t[0] == '[' {
+			if t[len(t)-1] != ']' {
+				return c, errf("invalid section '%s'", t)
+			}
+			section = strings.TrimSpace(t[1 : len(t)-1])
+			switch section {
+			case confSectionPipelines, confSectionQueries:
+			default:
+				return c, errf("unknown section '%s'", section)
+			}
+			continue
+		}
+
+		p := strings.SplitN(t, "=", 2)
+		if len(p) != 2 {
+			return c, errf("expected 'key = value'")
+		}
+		key, err := confKey(strings.TrimSpace(p[0]))
+		if err != nil {
+			return c, errf("%s", err)
+		}
+
+		raw := strings.TrimSpace(p[1])
+		for strings.HasPrefix(raw, "[") && !confArrayClosed(raw) && s.Scan() {
+			lineno++
+			raw += " " + strings.TrimSpace(stripConfComment(s.Text()))
+		}
+		values, err := confValues(raw)
+		if err != nil {
+			return c, errf("%s: %s", key, err)
+		}
+
+		id := section + "." + key
+		if _, ok := seen[id]; ok {
+			return c, errf("duplicate key '%s'", key)
+		}
+		seen[id] = struct{}{}
+
+		switch section {
+		case confSectionPipelines:
+			l := flags.CommaSep(strings.Join(values, ","))
+			if len(l) == 0 {
+				return c, errf("empty pipeline '%s'", key)
+			}
+			if _, ok := flags.AllActions[key]; ok {
+				return c, errf("pipeline '%s' shadows the action with the same name", key)
+			}
+			c.pipelines[key] = l
+		case confSectionQueries:
+			if err := validQueryName(key); err != nil {
+				return c, errf("%s", err)
+			}
+			if len(values) != 1 {
+				return c, errf("query '%s' should be a string", key)
+			}
+			c.queries[key] = values[0]
+		default:
+			if key == flags.BaseDir {
+				return c, errf("-%s can not be set from within the library", flags.BaseDir)
+			}
+			for _, v := range values {
+				c.args = append(c.args, confArg{name: key, value: v, set: true})
+			}
+		}
+	}
+
+	return c, s.Err()
+}
+
+func stripConfComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func confKey(k string) (string, error) {
+	if len(k) > 1 && (k[0] == '"' || k[0] == '\'') {
+		v, rest, err := confString(k)
+		if err != nil || rest != "" {
+			return "", fmt.Errorf("invalid key %s", k)
+		}
+		k = v
+	}
+	if k == "" || strings.ContainsAny(k, " \t=[]") {
+		return "", fmt.Errorf("invalid key '%s'", k)
+	}
+	return k, nil
+}
+
+func confArrayClosed(s string) bool {
+	s = stripConfComment(s)
+	var quote byte
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+func confString(s string) (string, string, error) {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if q == '"' {
+				i++
+			}
+		case q:
+			if q == '\'' {
+				return s[1:i], s[i+1:], nil
+			}
+			v, err := strconv.Unquote(s[:i+1])
+			return v, s[i+1:], err
+		}
+	}
+	return "", "", errors.New("unterminated string")
+}
+
+// confValues returns the string representation of a scalar or each value
+// of an array.
+func confValues(s string) ([]string, error) {
+	scalar := func(s string) (string, string, error) {
+		if s == "" {
+			return "", "", errors.New("missing value")
+		}
+		if s[0] == '"' || s[0] == '\'' {
+			return confString(s)
+		}
+		end := strings.IndexAny(s, ", ]")
+		if end == -1 {
+			end = len(s)
+		}
+		v, rest := s[:end], s[end:]
+		if v == "true" || v == "false" {
+			return v, rest, nil
+		}
+		if _, err := strconv.ParseFloat(strings.ReplaceAll(v, "_", ""), 64); err != nil {
+			return "", "", fmt.Errorf("invalid value '%s' (strings should be quoted)", v)
+		}
+		return strings.ReplaceAll(v, "_", ""), rest, nil
+	}
+
+	if s == "" || s[0] != '[' {
+		v, rest, err := scalar(s)
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(rest) != "" {
+			return nil, fmt.Errorf("unexpected '%s'", rest)
+		}
+		return []string{v}, nil
+	}
+
+	values := make([]string, 0)
+	s = strings.TrimSpace(s[1:])
+	for {
+		if s == "" {
+			return nil, errors.New("unterminated array")
+		}
+		if s[0] == ']' {
+			if strings.TrimSpace(s[1:]) != "" {
+				return nil, fmt.Errorf("unexpected '%s'", s[1:])
+			}
+			return values, nil
+		}
+		if s[0] == '[' {
+			return nil, errors.New("nested arrays are not supported")
+		}
+		v, rest, err := scalar(s)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		s = strings.TrimSpace(rest)
+		if s != "" && s[0] == ',' {
+			s = strings.TrimSpace(s[1:])
+		}
+	}
+}
+
+// applyConf sets all flags in args unless their name is in skip, the names
+// of all flags that were set are returned.
+func (f *Flags) applyConf(src string, args []confArg, skip map[string]struct{}) (map[string]struct{}, error) {
+	set := make(map[string]struct{})
+	for _, a := range args {
+		if _, ok := skip[a.name]; ok {
+			continue
+		}
+		fl := f.fs.Lookup(a.name)
+		if fl == nil {
+			return set, fmt.Errorf("%s: flag provided but not defined: -%s", src, a.name)
+		}
+		v := a.value
+		if !a.set {
+			if b, ok := fl.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				return set, fmt.Errorf("%s: flag needs an argument: -%s", src, a.name)
+			}
+			v = "true"
+		}
+		if err := f.fs.Set(a.name, v); err != nil {
+			return set, fmt.Errorf("%s: invalid value '%s' for flag -%s: %w", src, v, a.name, err)
+		}
+		set[a.name] = struct{}{}
+	}
+	return set, nil
+}
+
+// expandPipelines replaces pipeline names in actions with their actions.
+func expandPipelines(actions []string, pipelines Pipelines) ([]string, error) {
+	var expand func(l []string, stack []string) ([]string, error)
+	expand = func(l []string, stack []string) ([]string, error) {
+		res := make([]string, 0, len(l))
+		for _, a := range l {
+			p, ok := pipelines[a]
+			if !ok {
+				res = append(res, a)
+				continue
+			}
+			for _, s := range stack {
+				if s == a {
+					return nil, fmt.Errorf("pipeline '%s' references itself", a)
+				}
+			}
+			sub, err := expand(p, append(stack, a))
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, sub...)
+		}
+		return res, nil
+	}
+
+	return expand(actions, nil)
+}
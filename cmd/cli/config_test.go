@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfValues(t *testing.T) {
+	tests := []struct {
+		v   string
+		exp []string
+		err string
+	}{
+		{v: `"gimp"`, exp: []string{"gimp"}},
+		{v: `'C:\raws'`, exp: []string{`C:\raws`}},
+		{v: `"a \"b\"\t"`, exp: []string{"a \"b\"\t"}},
+		{v: `8`, exp: []string{"8"}},
+		{v: `1_000`, exp: []string{"1000"}},
+		{v: `-1.5`, exp: []string{"-1.5"}},
+		{v: `true`, exp: []string{"true"}},
+		{v: `[3840, 1920]`, exp: []string{"3840", "1920"}},
+		{v: `[ "a", 'b', 3, ]`, exp: []string{"a", "b", "3"}},
+		{v: `[]`, exp: []string{}},
+
+		{v: ``, err: "missing value"},
+		{v: `gimp`, err: "invalid value 'gimp' (strings should be quoted)"},
+		{v: `"gimp`, err: "unterminated string"},
+		{v: `"a" "b"`, err: `unexpected ' "b"'`},
+		{v: `[1, 2`, err: "unterminated array"},
+		{v: `[[1], [2]]`, err: "nested arrays are not supported"},
+		{v: `[1] 2`, err: "unexpected ' 2'"},
+	}
+
+	for _, test := range tests {
+		v, err := confValues(test.v)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: expected error %q got %v", test.v, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.v, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, test.exp) {
+			t.Errorf("%s: expected %q got %q", test.v, test.exp, v)
+		}
+	}
+}
+
+func TestStripConfComment(t *testing.T) {
+	tests := []struct{ line, exp string }{
+		{`a = 1 # comment`, `a = 1 `},
+		{`# comment`, ``},
+		{`a = "#not a comment" # comment`, `a = "#not a comment" `},
+		{`a = '#not' # comment`, `a = '#not' `},
+		{`a = "\"#not" # comment`, `a = "\"#not" `},
+		{`a = 1`, `a = 1`},
+	}
+	for _, test := range tests {
+		if v := stripConfComment(test.line); v != test.exp {
+			t.Errorf("%s: expected %q got %q", test.line, test.exp, v)
+		}
+	}
+}
+
+func TestLoadLibConf(t *testing.T) {
+	conf := `
+# flag defaults
+sizes   = [
+	3840, # 4k
+	1920,
+]
+workers = 8
+editor  = "gimp"
+"verbose" = true
+
+[pipelines]
+daily = "import,link,sync-meta"
+full  = ["daily", "previews"]
+
+[ queries ]
+keepers = "rating>=3 and not deleted"
+`
+	c, err := loadLibConf(writeConf(t, conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := []confArg{
+		{"sizes", "3840", true},
+		{"sizes", "1920", true},
+		{"workers", "8", true},
+		{"editor", "gimp", true},
+		{"verbose", "true", true},
+	}
+	if !reflect.DeepEqual(c.args, args) {
+		t.Errorf("expected args %+v got %+v", args, c.args)
+	}
+	pipelines := Pipelines{
+		"daily": {"import", "link", "sync-meta"},
+		"full":  {"daily", "previews"},
+	}
+	if !reflect.DeepEqual(c.pipelines, pipelines) {
+		t.Errorf("expected pipelines %v got %v", pipelines, c.pipelines)
+	}
+	queries := Queries{"keepers": "rating>=3 and not deleted"}
+	if !reflect.DeepEqual(c.queries, queries) {
+		t.Errorf("expected queries %v got %v", queries, c.queries)
+	}
+
+	c, err = loadLibConf(filepath.Join(t.TempDir(), "missing.toml"))
+	if err != nil || len(c.args) != 0 {
+		t.Errorf("a missing config should be empty: %v %+v", err, c)
+	}
+}
+
+func TestLoadLibConfErrors(t *testing.T) {
+	tests := []struct {
+		conf string
+		err  string
+	}{
+		{"[pipelines", ":1: invalid section '[pipelines'"},
+		{"[flags]", ":1: unknown section 'flags'"},
+		{"\n\nworkers", ":3: expected 'key = value'"},
+		{"= 8", ":1: invalid key ''"},
+		{"my key = 8", ":1: invalid key 'my key'"},
+		{`"key = 8`, `:1: invalid key "key`},
+		{"editor = gimp", ":1: editor: invalid value 'gimp' (strings should be quoted)"},
+		{"sizes = [1,\n2,\n", ":2: sizes: unterminated array"},
+		{"workers = 8\nworkers = 4", ":2: duplicate key 'workers'"},
+		{"base = \"/tmp\"", ":1: -base can not be set from within the library"},
+		{"[pipelines]\ndaily = \"\"", ":2: empty pipeline 'daily'"},
+		{"[pipelines]\nrate = \"import\"", ":2: pipeline 'rate' shadows the action with the same name"},
+		{"[queries]\nk = [\"a\", \"b\"]", ":2: query 'k' should be a string"},
+		{"[queries]\n\"a(b\" = \"rated\"", ":2: invalid query name 'a(b'"},
+	}
+
+	for _, test := range tests {
+		_, err := loadLibConf(writeConf(t, test.conf))
+		if err == nil {
+			t.Errorf("%q: expected error", test.conf)
+			continue
+		}
+		if !strings.HasSuffix(err.Error(), test.err) {
+			t.Errorf("%q: expected error ending in %q got %q", test.conf, test.err, err)
+		}
+	}
+}
+
+func TestExpandPipelines(t *testing.T) {
+	pipelines := Pipelines{
+		"daily": {"import", "link"},
+		"full":  {"daily", "previews", "daily"},
+		"a":     {"b"},
+		"b":     {"rate", "a"},
+	}
+
+	l, err := expandPipelines([]string{"full", "rate"}, pipelines)
+	exp := []string{"import", "link", "previews", "import", "link", "rate"}
+	if err != nil || !reflect.DeepEqual(l, exp) {
+		t.Errorf("expected %v got %v (%v)", exp, l, err)
+	}
+
+	if _, err := expandPipelines([]string{"a"}, pipelines); err == nil || err.Error() != "pipeline 'a' references itself" {
+		t.Errorf("expected a recursion error got %v", err)
+	}
+}
+
+func writeConf(t *testing.T, conf string) string {
+	p := filepath.Join(t.TempDir(), LibraryConfigFile)
+	if err := os.WriteFile(p, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
@@ -1,7 +1,6 @@
 package cli
 
 import (
-	"bufio"
 	"errors"
 	"flag"
 	"fmt"
@@ -20,6 +19,8 @@ import (
 	"github.com/frizinak/photos/cmd/flags"
 	"github.com/frizinak/photos/importer"
 	"github.com/frizinak/photos/meta"
+	"github.com/frizinak/photos/query"
+	"github.com/frizinak/photos/tags"
 )
 
 type flagStrs []string
@@ -113,11 +114,30 @@ func (l Lists) Help(cmd string) string { return l[cmd].String() + "\n" }
 
 var lists = Lists{
 	flags.Actions: {
-		help: "list of actions (comma separated and/or specified multiple times)",
+		help: "list of actions (comma separated and/or specified multiple times)\nor pipelines defined in <basedir>/photos.toml",
 		list: map[string][]string{
 			flags.ActionImport: {
 				"Import media from connected camera (gphoto2) and any given directory (-source) to the directory specified with -raws",
 			},
+			flags.ActionVerifyImport: {
+				"Resume an interrupted import and repair raws with a missing or incomplete .meta file",
+			},
+			flags.ActionTether: {
+				"Import, link and preview each shot as it is taken with a connected camera until ctrl-c",
+				"(see -tether-window)",
+			},
+			flags.ActionWatch: {
+				"Watch the -source directories and import, link and preview new files",
+				"once they are done being written to, until ctrl-c (see -settle)",
+			},
+			flags.ActionStack: {
+				"Group bursts and exposure brackets into stacks, each represented by a pick",
+				"the rater only shows the pick of a stack (see -stack-picks-only)",
+			},
+			flags.ActionSimilar: {
+				"Show clusters of near-duplicates based on the perceptual hash of their previews",
+				"(see -distance and -similar-rate)",
+			},
 			flags.ActionShow: {
 				"Show raws",
 			},
@@ -133,26 +153,44 @@ var lists = Lists{
 			flags.ActionShowTags: {
 				"Show all tags",
 			},
+			flags.ActionShowQueries: {
+				"Show all saved queries (see -q and -save-query)",
+			},
+			flags.ActionShowPipelines: {
+				"Show all action pipelines defined in <basedir>/photos.toml",
+			},
 			flags.ActionInfo: {
 				"Show info",
 			},
 			flags.ActionLink: {
 				"Create collection symlinks in the given directory (-collection)",
 			},
+			flags.ActionRelink: {
+				"Move existing collection symlinks (and their sidecars) to the layout given by -path-template",
+			},
 			flags.ActionPreviews: {
 				"Generate simple jpeg previews (used by -action rate)",
 			},
 			flags.ActionRate: {
 				"Simple opengl window to rate / trash images",
 			},
+			flags.ActionServe: {
+				"Serve previews over http (-listen) to rate / trash / tag images from a browser",
+				"works without opengl (nogl builds)",
+			},
 			flags.ActionEdit: {
 				"Run the phodo editor for each image.",
 			},
 			flags.ActionSyncMeta: {
-				"Sync .meta file with .pp3 (file mtime determines which one is the authority) and filesystem",
+				"Sync .meta file with .pp3 and .xmp (file mtime determines which one is the authority) and filesystem",
+				".xmp sidecars carry rating (-1 = trash), keywords, gps and capture date (darktable, digikam, ...)",
 			},
 			flags.ActionRewriteMeta: {
-				"Rewrite .meta, make sure you synced first so newer pp3s are not overwritten.",
+				"Rewrite .meta, make sure you synced first so newer pp3s / xmps are not overwritten.",
+			},
+			flags.ActionIndex: {
+				"Rebuild the .meta index used by filters and show-* actions.",
+				"Only needed when .meta files were modified outside of photos (e.g.: merging libraries with rsync).",
 			},
 			flags.ActionConvert: {
 				"Convert images to jpegs resized with -sizes",
@@ -161,6 +199,8 @@ var lists = Lists{
 				"photos ... -action convert -sizes 3840,1920 and later",
 				"photos ... -action convert -sizes 1920 will result in only the 1920 image being tracked",
 				"an -action cleanup will result in the deletion of all 3840 images",
+				"Rating, tags and location are embedded as xmp and iptc keywords,",
+				"changing them only rewrites the metadata of already converted jpegs",
 			},
 			flags.ActionExec: {
 				"Run an external command for each file (first non flag and any further arguments, {} is replaced with the filepath)",
@@ -171,6 +211,17 @@ var lists = Lists{
 				"all filters and -lt are ignored",
 				"Images whose rating is not higher than -gt will also have their jpegs deleted.",
 				"!Note: .meta files are seen as the single source of truth, so run sync-meta before",
+				"Deleted files are moved to the trash (see -action undo)",
+			},
+			flags.ActionUndo: {
+				"Undo changes to .meta files, file deletions and imports (imported files are trashed)",
+				"only the last 20 sessions (or 512MiB) of changes to .meta files are kept",
+				"without arguments: list all sessions (photos invocations) in the journal",
+				"with a session id as first non flag argument: undo that session and all later ones",
+				"e.g.: photos -base . -action undo 1690000000000000000",
+			},
+			flags.ActionEmptyTrash: {
+				"Permanently delete trashed files and clear the undo journal",
 			},
 			flags.ActionTagsRemove: {
 				"Remove tags (first non flag argument are the tags that will be removed)",
@@ -185,6 +236,21 @@ var lists = Lists{
 				"Update meta with location information extracted from google timeline kmls",
 				"requires -glocation flag with a directory where you downloaded history-YYYY-MM-DD.kml files",
 			},
+			flags.ActionGallery: {
+				"Export converted jpegs (of the single size given with -sizes) as a static html gallery to -gallery",
+				"with pages per day, per tag, per album and a map of all images with a location",
+			},
+			flags.ActionAlbumAdd: {
+				"Append the filtered files to the album given with -album (created if needed)",
+				"and materialize it as a directory of symlinks under -collection/-albums.",
+				"Links are prefixed with their position so convert and export-gallery respect the album order",
+			},
+			flags.ActionAlbumRemove: {
+				"Remove the filtered files from the album given with -album",
+			},
+			flags.ActionShowAlbums: {
+				"Show all albums (name, title and number of files)",
+			},
 			flags.ActionVersion: {
 				"Print version",
 			},
@@ -223,6 +289,9 @@ var lists = Lists{
 	flags.Video: {
 		help: "[any] only include videos",
 	},
+	flags.StackPicksOnly: {
+		help: "[any] only include the pick of each burst or bracket (see -action stack)",
+	},
 	flags.GT: {
 		help: "[any] only files with a rating greater than the one specified",
 	},
@@ -259,6 +328,40 @@ var lists = Lists{
 	flags.Until: {
 		help: "[any] until time filter [Y-m-d (H:M)]",
 	},
+	flags.Query: {
+		help: `[any] filter query, can be specified multiple times (and'ed)
+and combined with all other filter flags.
+e.g:
+-q 'rating>=3 or (tag:dog and lens:*35mm*)'
+-q 'not deleted and date:2023-06 and (camera:*x-t3 or @keepers)'
+
+operators:  and (&&, or juxtaposition), or (||), not (!), parentheses
+predicates: field:value (match), field=value, field!=value,
+            field>value, field>=value, field<value, field<=value
+fields:
+  rating          0-5
+  tag             * as wildcard, case insensitive, tag:- has no tags
+  camera, lens    make and model, * as wildcard, case insensitive
+  file            original filename, * as wildcard, case insensitive
+  ext             original file extension
+  location        location name or address, * as wildcard
+  album           album name, * as wildcard
+  date            Y, Y-m, Y-m-d or "Y-m-d H:M", date:2023-06 is all of june
+  since, until    same as -since and -until
+  aperture        f/2.8
+  shutter         1/200
+  iso             6400
+  focal           35mm
+  exposure        an -exposure rule
+keywords (same as their flag counterpart):
+  undeleted, deleted, updated, edited, unedited, rated, unrated,
+  location, nolocation, photo, video, stack-picks-only
+saved queries:
+  @name           (see -save-query)`,
+	},
+	flags.SaveQuery: {
+		help: "[any] save the -q query under the given name so it can be referenced as @name",
+	},
 	flags.Tags: {
 		help: `[any] tag filter, can be specified multiple times
 e.g:
@@ -272,8 +375,32 @@ special case: '*' only matches files with tags`,
 	flags.Checksum: {
 		help: "[import] dry-run and report non-identical files with duplicate filenames",
 	},
+	flags.Verify: {
+		help: "[import] checksum the source while copying and compare it with the copy,\nfiles that do not match are not imported and reported at the end\n(not supported when importing with the gphoto2 cli)",
+	},
+	flags.EraseSource: {
+		help: "[import] delete files from the source (-source, camera) once their checksum\nmatches a file in -raws, implies -verify. Asks for confirmation unless -y",
+	},
+	flags.TetherWindow: {
+		help: "[tether] show captures in the rater (see -action rate), jumping to each new one",
+	},
+	flags.WatchSettle: {
+		help: "[watch] seconds a file has to remain unchanged before it is imported",
+	},
+	flags.SimilarDistance: {
+		help: "[similar] maximum amount of bits the hashes of two near-duplicates may differ (0-64)",
+	},
+	flags.SimilarRate: {
+		help: "[similar] open each cluster in the rater (see -action rate)",
+	},
+	flags.EmbeddedPreview: {
+		help: "[previews] use the jpeg embedded in raws as their preview instead of\nrendering them using preview.pho, rawtherapee or imagemagick",
+	},
+	flags.EditedPreviews: {
+		help: "[previews] render the previews of edits whose .pp3 / .pho sidecar changed\ninstead of creating missing previews",
+	},
 	flags.ImportJPEG: {
-		help: "[import] also import jpegs",
+		help: "[import] also import jpegs, a jpeg shot alongside a raw is paired with it:\nrating, deletion and tags are kept in sync and the rater only shows the raw",
 	},
 	flags.Sizes: {
 		help: "comma separated and/or specified multiple times (e.g.: 3840,1920,800)",
@@ -281,8 +408,9 @@ special case: '*' only matches files with tags`,
 			"[convert]": {
 				"longest image dimension will be scaled to this size ",
 			},
-			"[show-jpegs]": {"filter on jpeg sizes"},
-			"[gphotos]":    {"filter on jpeg sizes"},
+			"[show-jpegs]":     {"filter on jpeg sizes"},
+			"[gphotos]":        {"filter on jpeg sizes"},
+			"[export-gallery]": {"the jpeg size to export"},
 		},
 	},
 	flags.RawDir: {
@@ -299,7 +427,20 @@ special case: '*' only matches files with tags`,
 -raws (if not given)       = <basedir>/Originals
 -collection (if not given) = <basedir>/Collection
 -jpegs (if not given)      = <basedir>/Converted
--gphotos (if not given)    = <basedir>/gphotos.credentials`,
+-gphotos (if not given)    = <basedir>/gphotos.credentials
+-gallery (if not given)    = <basedir>/Gallery
+
+<basedir>/photos.toml, if it exists, provides defaults for all other flags
+(explicit flags take precedence), named action pipelines and query presets:
+
+  sizes   = [3840, 1920]
+  workers = 8
+
+  [pipelines]
+  daily = "import,link,sync-meta,link,previews"
+
+  [queries]
+  keepers = "rating>=3 and not deleted"`,
 	},
 	flags.GPhotosCredentials: {
 		help: "[gphotos] path to the google credentials file",
@@ -307,11 +448,52 @@ special case: '*' only matches files with tags`,
 	flags.GLocationDirectory: {
 		help: "[glocation] directory holding history-YYYY-MM-DD.kml files",
 	},
+	flags.GalleryDir: {
+		help: "[export-gallery] directory the static html gallery is written to",
+	},
+	flags.PreviewCache: {
+		help: "[previews,rate,serve,similar] directory previews are cached in (default: <raws>/.previews)",
+	},
+	flags.PreviewCacheSize: {
+		help: "[previews] maximum size of the preview cache in MiB,\nthe least recently used previews are removed once it is exceeded (0: unlimited)",
+	},
+	flags.Album: {
+		help: `[add-to-album,remove-from-album] album name
+[link] only materialize this album
+[export-gallery] only export this album
+use -q album:<name> to filter on album membership`,
+	},
+	flags.AlbumTitle: {
+		help: "[add-to-album] set the album title (defaults to its name)",
+	},
+	flags.AlbumCover: {
+		help: "[add-to-album] use the first added file as the album cover",
+	},
+	flags.PathTemplate: {
+		help: fmt.Sprintf(`[link,relink] layout of the links in -collection
+defaults to the first line of <collection>/%s or %s
+e.g.: '{camera}/{year}/{location|elsewhere}/{month}-{day}--{filename}'
+
+{var}, {var:arg} or {var|default if empty}, variables:
+%s`,
+			PathTemplateFile,
+			importer.DefaultPathTemplate,
+			strings.Join(importer.PathTemplateVars(), ", "),
+		),
+	},
+	flags.AlbumDir: {
+		help: "[any] directory relative to -collection albums are materialized in",
+	},
+	flags.Listen: {
+		help: `[serve] address to listen on
+there is no authentication: on a non-loopback address (e.g.: 0.0.0.0:8080)
+anyone on the network can rate, tag and trash images, use it only on trusted networks`,
+	},
 	flags.MaxWorkers: {
 		help: "[all] maximum amount of threads",
 	},
 	flags.SourceDir: {
-		help: "[import] filesystem paths to import from, can be specified multiple times",
+		help: "[import, watch] filesystem paths to import from, can be specified multiple times",
 	},
 	flags.AlwaysYes: {
 		help: "always answer yes",
@@ -323,6 +505,10 @@ e.g.: photos -base . -0 -action show-jpegs -no-raw | xargs -0 feh`,
 	flags.NoRawPrefix: {
 		help: "[show-*] don't prefix output with the corresponding raw file",
 	},
+	flags.Format: {
+		help: `[info,show-*,similar] output format: json, ndjson or csv (lists in csv are separated by |)
+every record holds the full meta, links and preview path`,
+	},
 	flags.Verbose: {
 		help: "enable verbose stderr logging",
 	},
@@ -346,7 +532,11 @@ type Flags struct {
 	lens     []string
 	exposure []string
 	tags     [][][]string
-	rating   struct {
+	query    query.Node
+	queries  Queries
+
+	pipelines Pipelines
+	rating    struct {
 		gt, lt int
 	}
 
@@ -358,7 +548,17 @@ type Flags struct {
 
 	sourceDirs []string
 
-	checksum bool
+	checksum    bool
+	verify      bool
+	eraseSource bool
+
+	tetherWindow bool
+	watchSettle  time.Duration
+
+	similarDistance int
+	similarRate     bool
+
+	editedPreviews bool
 
 	importJPEG bool
 
@@ -372,11 +572,24 @@ type Flags struct {
 
 	noRawPrefix bool
 	zero        bool
+	format      string
 
 	maxWorkers int
 
 	gphotos   string
 	glocation string
+	gallery   string
+	listen    string
+
+	previewCache     string
+	previewCacheSize int64
+
+	album      string
+	albumTitle string
+	albumCover bool
+	albumDir   string
+
+	pathTemplate *importer.PathTemplate
 
 	phodoConf    *phodo.Conf
 	phodoDefault string
@@ -420,10 +633,21 @@ func (f *Flags) JPEGDir() string       { return f.jpegDir }
 
 func (f *Flags) SourceDirs() []string { return f.sourceDirs }
 
-func (f *Flags) Checksum() bool    { return f.checksum }
-func (f *Flags) ImportJPEG() bool  { return f.importJPEG }
-func (f *Flags) Yes() bool         { return f.alwaysYes }
-func (f *Flags) NoRawPrefix() bool { return f.noRawPrefix }
+func (f *Flags) Checksum() bool             { return f.checksum }
+func (f *Flags) Verify() bool               { return f.verify }
+func (f *Flags) EraseSource() bool          { return f.eraseSource }
+func (f *Flags) TetherWindow() bool         { return f.tetherWindow }
+func (f *Flags) WatchSettle() time.Duration { return f.watchSettle }
+func (f *Flags) SimilarDistance() int       { return f.similarDistance }
+func (f *Flags) SimilarRate() bool          { return f.similarRate }
+func (f *Flags) EditedPreviews() bool       { return f.editedPreviews }
+func (f *Flags) ImportJPEG() bool           { return f.importJPEG }
+func (f *Flags) Yes() bool                  { return f.alwaysYes }
+func (f *Flags) NoRawPrefix() bool          { return f.noRawPrefix }
+func (f *Flags) Format() string             { return f.format }
+
+func (f *Flags) Queries() Queries     { return f.queries }
+func (f *Flags) Pipelines() Pipelines { return f.pipelines }
 
 func (f *Flags) Args() []string { return f.fs.Args() }
 
@@ -469,9 +693,89 @@ func (f *Flags) TimeOverride() time.Time { return f.timeOverride }
 
 func (f *Flags) GPhotosCredentials() string { return f.gphotos }
 func (f *Flags) GLocationDirectory() string { return f.glocation }
+func (f *Flags) GalleryDir() string         { return f.gallery }
+func (f *Flags) Listen() string             { return f.listen }
+
+func (f *Flags) PreviewCache() string    { return f.previewCache }
+func (f *Flags) PreviewCacheSize() int64 { return f.previewCacheSize }
+
+func (f *Flags) Album() string      { return f.album }
+func (f *Flags) AlbumTitle() string { return f.albumTitle }
+func (f *Flags) AlbumCover() bool   { return f.albumCover }
+func (f *Flags) AlbumDir() string   { return f.albumDir }
+
+func (f *Flags) PathTemplate() *importer.PathTemplate { return f.pathTemplate }
 
 func (f *Flags) Log() *log.Logger { return f.log }
 
+// boolFilter returns the Filter or MetaFilter for one of the boolean filter
+// flags. weight orders expensive filters last.
+func (f *Flags) boolFilter(filter string, imp *importer.Importer) (_f Filter, _mf MetaFilter, weight int, err error) {
+	switch filter {
+	case flags.Undeleted:
+		_mf = func(meta meta.Meta, fl *importer.File) bool {
+			return !meta.Deleted
+		}
+	case flags.Deleted:
+		_mf = func(meta meta.Meta, fl *importer.File) bool {
+			return meta.Deleted
+		}
+	case flags.Rated:
+		_mf = func(meta meta.Meta, fl *importer.File) bool {
+			return meta.Rating > 0
+		}
+	case flags.Unrated:
+		_mf = func(meta meta.Meta, fl *importer.File) bool {
+			return meta.Rating == 0
+		}
+	case flags.Updated:
+		sizes := f.Sizes()
+		if len(sizes) == 0 {
+			return nil, nil, 0, errors.New("no sizes specified")
+		}
+		weight = 99
+		_mf = func(meta meta.Meta, fl *importer.File) bool {
+			c, err := imp.CheckConvert(fl, sizes)
+			f.Exit(err)
+			return c
+		}
+	case flags.Edited:
+		weight = 50
+		_mf = func(meta meta.Meta, fl *importer.File) bool {
+			b, err := imp.Unedited(fl)
+			f.Exit(err)
+			return !b
+		}
+	case flags.Unedited:
+		weight = 50
+		_mf = func(meta meta.Meta, fl *importer.File) bool {
+			b, err := imp.Unedited(fl)
+			f.Exit(err)
+			return b
+		}
+	case flags.Location:
+		_mf = func(meta meta.Meta, fl *importer.File) bool {
+			return meta.Location != nil
+		}
+	case flags.NoLocation:
+		_mf = func(meta meta.Meta, fl *importer.File) bool {
+			return meta.Location == nil
+		}
+	case flags.Photo:
+		_f = func(fl *importer.File) bool { return fl.TypeImage() || fl.TypeRAW() }
+	case flags.Video:
+		_f = func(fl *importer.File) bool { return fl.TypeVideo() }
+	case flags.StackPicksOnly:
+		_mf = func(meta meta.Meta, fl *importer.File) bool {
+			return meta.Stack == "" || meta.Pick
+		}
+	default:
+		return nil, nil, 0, fmt.Errorf("unknown filter %s", filter)
+	}
+
+	return
+}
+
 func (f *Flags) makeFilters(imp *importer.Importer) {
 	if f.mfilterFuncs != nil {
 		return
@@ -482,66 +786,8 @@ func (f *Flags) makeFilters(imp *importer.Importer) {
 		if !enabled {
 			continue
 		}
-		var _mf MetaFilter
-		var _f Filter
-		var weight int
-		switch filter {
-		case flags.Undeleted:
-			_mf = func(meta meta.Meta, fl *importer.File) bool {
-				return !meta.Deleted
-			}
-		case flags.Deleted:
-			_mf = func(meta meta.Meta, fl *importer.File) bool {
-				return meta.Deleted
-			}
-		case flags.Rated:
-			_mf = func(meta meta.Meta, fl *importer.File) bool {
-				return meta.Rating > 0
-			}
-		case flags.Unrated:
-			_mf = func(meta meta.Meta, fl *importer.File) bool {
-				return meta.Rating == 0
-			}
-		case flags.Updated:
-			sizes := f.Sizes()
-			if len(sizes) == 0 {
-				f.Exit(errors.New("no sizes specified"))
-			}
-			weight = 99
-			_mf = func(meta meta.Meta, fl *importer.File) bool {
-				c, err := imp.CheckConvert(fl, sizes)
-				f.Exit(err)
-				return c
-			}
-		case flags.Edited:
-			weight = 50
-			_mf = func(meta meta.Meta, fl *importer.File) bool {
-				b, err := imp.Unedited(fl)
-				f.Exit(err)
-				return !b
-			}
-		case flags.Unedited:
-			weight = 50
-			_mf = func(meta meta.Meta, fl *importer.File) bool {
-				b, err := imp.Unedited(fl)
-				f.Exit(err)
-				return b
-			}
-		case flags.Location:
-			_mf = func(meta meta.Meta, fl *importer.File) bool {
-				return meta.Location != nil
-			}
-		case flags.NoLocation:
-			_mf = func(meta meta.Meta, fl *importer.File) bool {
-				return meta.Location == nil
-			}
-		case flags.Photo:
-			_f = func(fl *importer.File) bool { return fl.TypeImage() || fl.TypeRAW() }
-		case flags.Video:
-			_f = func(fl *importer.File) bool { return fl.TypeVideo() }
-		default:
-			f.Exit(fmt.Errorf("unknown filter %s", filter))
-		}
+		_f, _mf, weight, err := f.boolFilter(filter, imp)
+		f.Exit(err)
 
 		if _f != nil {
 			list = append(list, FilterWeight{_f, weight})
@@ -551,6 +797,12 @@ func (f *Flags) makeFilters(imp *importer.Importer) {
 		}
 	}
 
+	if f.query != nil {
+		mf, err := f.compileQuery(f.query, imp)
+		f.Err(err)
+		mlist = append(mlist, mf)
+	}
+
 	f.mfilterFuncs = mlist
 	f.filterFuncs = list
 
@@ -680,95 +932,11 @@ func (f *Flags) MetaFilter(imp *importer.Importer) MetaFilter {
 			}
 
 			for _, rule := range f.exposure {
-				if len(rule) < 3 {
-					f.Err(fmt.Errorf("invalid exposure rule: '%s'", rule))
-				}
-
-				rule = strings.ToLower(rule)
-				comp := 0
-				if rule[0] == '+' {
-					rule = rule[1:]
-					comp = 1
-				} else if rule[0] == '-' {
-					rule = rule[1:]
-					comp = 2
-				}
-
-				switch {
-				case strings.Contains(rule, "f/"):
-					rule = strings.Replace(rule, "f/", "", 1)
-					fnum, err := strconv.ParseFloat(rule, 32)
-					if err != nil {
-						f.Err(fmt.Errorf("invalid aperture value: '%s'", rule))
-					}
-					switch {
-					case comp == 0 && fnum != m.CameraInfo.Aperture.Float():
-						return false
-					case comp == 1 && fnum > m.CameraInfo.Aperture.Float():
-						return false
-					case comp == 2 && fnum < m.CameraInfo.Aperture.Float():
-						return false
-					}
-				case strings.Contains(rule, "iso"):
-					rule = strings.Replace(rule, "iso", "", 1)
-					iso, err := strconv.ParseFloat(rule, 32)
-					if err != nil {
-						f.Err(fmt.Errorf("invalid iso value: '%s'", rule))
-					}
-					switch {
-					case comp == 0 && iso != float64(m.CameraInfo.ISO):
-						return false
-					case comp == 1 && iso > float64(m.CameraInfo.ISO):
-						return false
-					case comp == 2 && iso < float64(m.CameraInfo.ISO):
-						return false
-					}
-				case strings.Contains(rule, "s"):
-					rule = strings.Replace(rule, "s", "", 1)
-					p := strings.SplitN(rule, "/", 2)
-					if len(p) > 2 {
-						f.Err(fmt.Errorf("invalid shutter speed value: '%s'", rule))
-					}
-
-					nom, err := strconv.ParseFloat(p[0], 32)
-					if err != nil {
-						f.Err(fmt.Errorf("invalid shutters speed value: '%s'", rule))
-					}
-					denom := 1.0
-					if len(p) == 2 {
-						denom, err = strconv.ParseFloat(p[1], 32)
-						if err != nil {
-							f.Err(fmt.Errorf("invalid shutters speed value: '%s'", rule))
-						}
-					}
-
-					ss := nom / denom
-					switch {
-					case comp == 0 && math.Abs(ss-m.CameraInfo.ShutterSpeed.Float()) > 1.0/256000:
-						return false
-					case comp == 1 && ss < m.CameraInfo.ShutterSpeed.Float():
-						return false
-					case comp == 2 && ss > m.CameraInfo.ShutterSpeed.Float():
-						return false
-					}
-				case strings.Contains(rule, "mm"):
-					rule = strings.Replace(rule, "mm", "", 1)
-					fl, err := strconv.ParseFloat(rule, 32)
-					if err != nil {
-						f.Err(fmt.Errorf("invalid focal length value: '%s'", rule))
-					}
-					switch {
-					case comp == 0 && fl != m.CameraInfo.FocalLength.Float():
-						return false
-					case comp == 1 && fl > m.CameraInfo.FocalLength.Float():
-						return false
-					case comp == 2 && fl < m.CameraInfo.FocalLength.Float():
-						return false
-					}
-				default:
-					f.Err(fmt.Errorf("invalid exposure rule: '%s'", rule))
+				ok, err := exposureRule(rule, m.CameraInfo)
+				f.Err(err)
+				if !ok {
+					return false
 				}
-
 			}
 		}
 
@@ -805,14 +973,32 @@ func (f *Flags) Parse() {
 	var rawDir, collectionDir, jpegDir string
 	var fsSources flagStrs
 	var checksum bool
+	var verify bool
+	var eraseSource bool
+	var tetherWindow bool
+	var watchSettle int
+	var similarDistance int
+	var similarRate bool
+	var embeddedPreview bool
+	var editedPreviews bool
 	var sizes flagStrs
 	var alwaysYes bool
 	var zero bool
 	var maxWorkers int
 	var noRawPrefix bool
+	var format string
 	var tags flagStrs
+	var queries flagStrs
+	var saveQuery string
 	var gphotos string
 	var glocation string
+	var gallery string
+	var previewCache string
+	var previewCacheSize int
+	var listen string
+	var album, albumTitle, albumDir string
+	var albumCover bool
+	var pathTemplate string
 	var since, until string
 	var help bool
 	var importJPEG bool
@@ -830,6 +1016,7 @@ func (f *Flags) Parse() {
 	var noLocation bool
 	var photo bool
 	var video bool
+	var stackPicksOnly bool
 	var timeOverride string
 
 	f.fs.BoolVar(&help, "h", false, "\nhelp\n")
@@ -846,6 +1033,7 @@ func (f *Flags) Parse() {
 	f.fs.BoolVar(&noLocation, flags.NoLocation, false, f.lists.Help(flags.NoLocation))
 	f.fs.BoolVar(&photo, flags.Photo, false, f.lists.Help(flags.Photo))
 	f.fs.BoolVar(&video, flags.Video, false, f.lists.Help(flags.Video))
+	f.fs.BoolVar(&stackPicksOnly, flags.StackPicksOnly, false, f.lists.Help(flags.StackPicksOnly))
 
 	f.fs.IntVar(&ratingGT, flags.GT, -1, f.lists.Help(flags.GT))
 	f.fs.IntVar(&ratingLT, flags.LT, 6, f.lists.Help(flags.LT))
@@ -858,8 +1046,18 @@ func (f *Flags) Parse() {
 	f.fs.StringVar(&until, flags.Until, "", f.lists.Help(flags.Until))
 
 	f.fs.Var(&tags, flags.Tags, f.lists.Help(flags.Tags))
+	f.fs.Var(&queries, flags.Query, f.lists.Help(flags.Query))
+	f.fs.StringVar(&saveQuery, flags.SaveQuery, "", f.lists.Help(flags.SaveQuery))
 
 	f.fs.BoolVar(&checksum, flags.Checksum, false, f.lists.Help(flags.Checksum))
+	f.fs.BoolVar(&verify, flags.Verify, false, f.lists.Help(flags.Verify))
+	f.fs.BoolVar(&eraseSource, flags.EraseSource, false, f.lists.Help(flags.EraseSource))
+	f.fs.BoolVar(&tetherWindow, flags.TetherWindow, false, f.lists.Help(flags.TetherWindow))
+	f.fs.IntVar(&watchSettle, flags.WatchSettle, 5, f.lists.Help(flags.WatchSettle))
+	f.fs.IntVar(&similarDistance, flags.SimilarDistance, 8, f.lists.Help(flags.SimilarDistance))
+	f.fs.BoolVar(&similarRate, flags.SimilarRate, false, f.lists.Help(flags.SimilarRate))
+	f.fs.BoolVar(&embeddedPreview, flags.EmbeddedPreview, false, f.lists.Help(flags.EmbeddedPreview))
+	f.fs.BoolVar(&editedPreviews, flags.EditedPreviews, false, f.lists.Help(flags.EditedPreviews))
 	f.fs.BoolVar(&importJPEG, flags.ImportJPEG, false, f.lists.Help(flags.ImportJPEG))
 	f.fs.Var(&sizes, flags.Sizes, f.lists.Help(flags.Sizes))
 
@@ -869,6 +1067,15 @@ func (f *Flags) Parse() {
 
 	f.fs.StringVar(&gphotos, flags.GPhotosCredentials, "", f.lists.Help(flags.GPhotosCredentials))
 	f.fs.StringVar(&glocation, flags.GLocationDirectory, "", f.lists.Help(flags.GLocationDirectory))
+	f.fs.StringVar(&gallery, flags.GalleryDir, "", f.lists.Help(flags.GalleryDir))
+	f.fs.StringVar(&previewCache, flags.PreviewCache, "", f.lists.Help(flags.PreviewCache))
+	f.fs.IntVar(&previewCacheSize, flags.PreviewCacheSize, importer.DefaultPreviewCacheSize>>20, f.lists.Help(flags.PreviewCacheSize))
+	f.fs.StringVar(&listen, flags.Listen, "localhost:8080", f.lists.Help(flags.Listen))
+	f.fs.StringVar(&album, flags.Album, "", f.lists.Help(flags.Album))
+	f.fs.StringVar(&albumTitle, flags.AlbumTitle, "", f.lists.Help(flags.AlbumTitle))
+	f.fs.BoolVar(&albumCover, flags.AlbumCover, false, f.lists.Help(flags.AlbumCover))
+	f.fs.StringVar(&pathTemplate, flags.PathTemplate, "", f.lists.Help(flags.PathTemplate))
+	f.fs.StringVar(&albumDir, flags.AlbumDir, importer.DefaultAlbumDir, f.lists.Help(flags.AlbumDir))
 
 	f.fs.IntVar(&maxWorkers, flags.MaxWorkers, 100, f.lists.Help(flags.MaxWorkers))
 
@@ -879,6 +1086,7 @@ func (f *Flags) Parse() {
 	f.fs.BoolVar(&alwaysYes, flags.AlwaysYes, false, f.lists.Help(flags.AlwaysYes))
 	f.fs.BoolVar(&zero, flags.Zero, false, f.lists.Help(flags.Zero))
 	f.fs.BoolVar(&noRawPrefix, flags.NoRawPrefix, false, f.lists.Help(flags.NoRawPrefix))
+	f.fs.StringVar(&format, flags.Format, "", f.lists.Help(flags.Format))
 
 	f.fs.BoolVar(&verbose, flags.Verbose, false, f.lists.Help(flags.Verbose))
 
@@ -886,38 +1094,28 @@ func (f *Flags) Parse() {
 
 	f.fs.StringVar(&timeOverride, flags.TimeOverride, "", f.lists.Help(flags.TimeOverride))
 
+	var phodoPreview string
 	uconfdir, err := os.UserConfigDir()
-	confArgs := make([]string, 0)
+	var confArgs []confArg
+	queryFile := ""
 	if err == nil {
 		confdir := filepath.Join(uconfdir, "photos")
+		queryFile = filepath.Join(confdir, "queries.conf")
 		conffile := filepath.Join(confdir, "photos.conf")
-		fl, err := os.Open(conffile)
-		if os.IsNotExist(err) {
+		if _, err := os.Stat(conffile); os.IsNotExist(err) {
 			os.MkdirAll(confdir, 0755)
-			fl, err = os.Create(conffile)
+			fl, err := os.Create(conffile)
 			f.Err(err)
 			fmt.Fprintln(fl, "# -base /home/user/RAW")
-			//fl.Seek(0, io.SeekStart)
+			fl.Close()
 		}
+		confArgs, err = loadGlobalConf(conffile)
 		if err != nil {
 			f.Err(fmt.Errorf("failed opening config file '%s': %w", conffile, err))
 		}
 
-		s := bufio.NewScanner(fl)
-		s.Split(bufio.ScanLines)
-		for s.Scan() {
-			t := strings.TrimSpace(s.Text())
-			p := strings.SplitN(t, " ", 2)
-			confArgs = append(confArgs, p[0])
-			if len(p) == 2 {
-				confArgs = append(confArgs, strings.TrimSpace(p[1]))
-			}
-		}
-
-		f.Err(s.Err())
-
 		f.phodoDefault = filepath.Join(confdir, "default.pho")
-		phodoPreview := filepath.Join(confdir, "preview.pho")
+		phodoPreview = filepath.Join(confdir, "preview.pho")
 		cnot := func(path string, def string) error {
 			_, err := os.Stat(path)
 			if !os.IsNotExist(err) {
@@ -959,25 +1157,45 @@ func (f *Flags) Parse() {
 )
 `))
 
-		importer.RegisterPreviewGen(&importer.PhoPreviewGen{phodoPreview})
 	}
 
-	importer.RegisterPreviewGen(&importer.RTPreviewGen{})
-	importer.RegisterPreviewGen(&importer.IMPreviewGen{})
-	importer.RegisterPreviewGen(&importer.VidPreviewGen{})
+	// explicit flags > <basedir>/photos.toml > <confdir>/photos.conf
+	f.Err(f.fs.Parse(os.Args[1:]))
+	explicit := make(map[string]struct{})
+	f.fs.Visit(func(fl *flag.Flag) { explicit[fl.Name] = struct{}{} })
 
-	if len(confArgs) != 0 {
-		f.Err(f.fs.Parse(confArgs))
+	base := make([]confArg, 0, 1)
+	for _, a := range confArgs {
+		if a.name == flags.BaseDir {
+			base = append(base, a)
+		}
 	}
+	_, err = f.applyConf("photos.conf", base, explicit)
+	f.Err(err)
+	explicit[flags.BaseDir] = struct{}{}
 
-	f.Err(f.fs.Parse(os.Args[1:]))
+	lib := libConf{pipelines: make(Pipelines), queries: make(Queries)}
+	if baseDir != "" {
+		libFile := filepath.Join(baseDir, LibraryConfigFile)
+		lib, err = loadLibConf(libFile)
+		f.Err(err)
+		libSet, err := f.applyConf(libFile, lib.args, explicit)
+		f.Err(err)
+		for n := range libSet {
+			explicit[n] = struct{}{}
+		}
+	}
+	_, err = f.applyConf("photos.conf", confArgs, explicit)
+	f.Err(err)
+	f.pipelines = lib.pipelines
 
 	if help {
 		f.fs.PrintDefaults()
 		os.Exit(0)
 	}
 
-	f.actions = flags.CommaSep(strings.Join(actions, ","))
+	f.actions, err = expandPipelines(flags.CommaSep(strings.Join(actions, ",")), f.pipelines)
+	f.Err(err)
 	if len(f.actions) == 0 {
 		f.Err(errors.New("no actions provided"))
 	}
@@ -989,17 +1207,18 @@ func (f *Flags) Parse() {
 	}
 
 	f.filters = map[string]bool{
-		flags.Undeleted:  undeleted,
-		flags.Deleted:    deleted,
-		flags.Updated:    updated,
-		flags.Edited:     edited,
-		flags.Unedited:   unedited,
-		flags.Rated:      rated,
-		flags.Unrated:    unrated,
-		flags.Location:   location,
-		flags.NoLocation: noLocation,
-		flags.Photo:      photo,
-		flags.Video:      video,
+		flags.Undeleted:      undeleted,
+		flags.Deleted:        deleted,
+		flags.Updated:        updated,
+		flags.Edited:         edited,
+		flags.Unedited:       unedited,
+		flags.Rated:          rated,
+		flags.Unrated:        unrated,
+		flags.Location:       location,
+		flags.NoLocation:     noLocation,
+		flags.Photo:          photo,
+		flags.Video:          video,
+		flags.StackPicksOnly: stackPicksOnly,
 	}
 
 	if baseDir != "" {
@@ -1015,6 +1234,9 @@ func (f *Flags) Parse() {
 		if gphotos == "" {
 			gphotos = filepath.Join(baseDir, "gphotos.credentials")
 		}
+		if gallery == "" {
+			gallery = filepath.Join(baseDir, "Gallery")
+		}
 	}
 
 	if rawDir == "" {
@@ -1049,6 +1271,61 @@ func (f *Flags) Parse() {
 		}
 	}
 
+	globalQueries := make(Queries)
+	if queryFile != "" {
+		globalQueries, err = loadQueries(queryFile)
+		f.Err(err)
+	}
+	f.queries = make(Queries, len(globalQueries)+len(lib.queries))
+	for n, q := range globalQueries {
+		f.queries[n] = q
+	}
+	for n, q := range lib.queries {
+		f.queries[n] = q
+	}
+
+	qs := make([]string, 0, len(queries))
+	for _, q := range queries {
+		n, err := query.Parse(q)
+		if err != nil {
+			f.Err(fmt.Errorf("invalid -%s '%s': %w", flags.Query, q, err))
+		}
+		if f.query == nil {
+			f.query = n
+		} else {
+			f.query = query.And{L: f.query, R: n}
+		}
+		qs = append(qs, q)
+	}
+
+	if saveQuery != "" {
+		f.Err(validQueryName(saveQuery))
+		if f.query == nil {
+			f.Err(fmt.Errorf("-%s requires a -%s", flags.SaveQuery, flags.Query))
+		}
+		if queryFile == "" {
+			f.Err(errors.New("no config directory to save queries to"))
+		}
+		str := qs[0]
+		if len(qs) > 1 {
+			str = "(" + strings.Join(qs, ") and (") + ")"
+		}
+		if _, ok := lib.queries[saveQuery]; ok {
+			f.Err(fmt.Errorf("@%s is a preset in %s", saveQuery, LibraryConfigFile))
+		}
+		f.queries[saveQuery] = str
+		globalQueries[saveQuery] = str
+		if _, err := query.Resolve(f.query, f.queries.lookup); err != nil {
+			f.Err(err)
+		}
+		f.Err(saveQueries(queryFile, globalQueries))
+	}
+
+	if f.query != nil {
+		f.query, err = query.Resolve(f.query, f.queries.lookup)
+		f.Err(err)
+	}
+
 	f.time.since, err = parseTime(since, false)
 	f.Err(err)
 	f.time.until, err = parseTime(until, true)
@@ -1085,14 +1362,61 @@ func (f *Flags) Parse() {
 	f.rating.gt = ratingGT
 	f.rating.lt = ratingLT
 	f.checksum = checksum
+	f.verify = verify
+	f.eraseSource = eraseSource
+	f.tetherWindow = tetherWindow
+	f.watchSettle = time.Duration(watchSettle) * time.Second
+	f.similarDistance = similarDistance
+	f.similarRate = similarRate
+	f.editedPreviews = editedPreviews
+
+	if embeddedPreview {
+		importer.RegisterPreviewGen(&importer.EmbeddedPreviewGen{})
+	}
+	if phodoPreview != "" {
+		importer.RegisterPreviewGen(&importer.PhoPreviewGen{phodoPreview})
+	}
+	importer.RegisterPreviewGen(&importer.RTPreviewGen{})
+	importer.RegisterPreviewGen(&importer.IMPreviewGen{})
+	importer.RegisterPreviewGen(&importer.VidPreviewGen{})
+
 	f.importJPEG = importJPEG
 	f.alwaysYes = alwaysYes
 	f.noRawPrefix = noRawPrefix
 	f.zero = zero
+	f.format = strings.ToLower(format)
+	switch f.format {
+	case "", flags.FormatJSON, flags.FormatNDJSON, flags.FormatCSV:
+	default:
+		f.Err(fmt.Errorf("invalid -%s '%s'", flags.Format, format))
+	}
 	f.maxWorkers = maxWorkers
 	f.rawDir, f.collectionDir, f.jpegDir = rawDir, collectionDir, jpegDir
 	f.gphotos = gphotos
 	f.glocation = glocation
+	f.gallery = gallery
+	f.previewCache = previewCache
+	if previewCache == "" {
+		f.previewCache = filepath.Join(rawDir, importer.DefaultPreviewDir)
+	}
+	f.previewCacheSize = int64(previewCacheSize) << 20
+	f.listen = listen
+	f.album = album
+	f.albumTitle = albumTitle
+	f.albumCover = albumCover
+	if pathTemplate == "" {
+		pathTemplate, err = readPathTemplate(filepath.Join(collectionDir, PathTemplateFile))
+		f.Err(err)
+	}
+	f.pathTemplate, err = importer.ParsePathTemplate(pathTemplate)
+	if err != nil {
+		f.Err(fmt.Errorf("invalid -%s '%s': %w", flags.PathTemplate, pathTemplate, err))
+	}
+
+	f.albumDir = filepath.Clean(albumDir)
+	if albumDir == "" || filepath.IsAbs(f.albumDir) || strings.HasPrefix(f.albumDir, "..") {
+		f.Err(fmt.Errorf("-%s should be a directory relative to -%s", flags.AlbumDir, flags.CollectionDir))
+	}
 	f.verbose = verbose
 	f.editor = editor
 
@@ -1108,6 +1432,121 @@ func (f *Flags) Parse() {
 	}
 }
 
+// exposureRule matches a single -exposure rule (e.g.: +f/2.0, -1/5s, iso6400
+// or +32mm) against c.
+func exposureRule(rule string, c *tags.CameraInfo) (bool, error) {
+	if len(rule) < 3 {
+		return false, fmt.Errorf("invalid exposure rule: '%s'", rule)
+	}
+
+	rule = strings.ToLower(rule)
+	comp := 0
+	if rule[0] == '+' {
+		rule = rule[1:]
+		comp = 1
+	} else if rule[0] == '-' {
+		rule = rule[1:]
+		comp = 2
+	}
+
+	switch {
+	case strings.Contains(rule, "f/"):
+		rule = strings.Replace(rule, "f/", "", 1)
+		fnum, err := strconv.ParseFloat(rule, 32)
+		if err != nil {
+			return false, fmt.Errorf("invalid aperture value: '%s'", rule)
+		}
+		switch {
+		case comp == 0 && fnum != c.Aperture.Float():
+			return false, nil
+		case comp == 1 && fnum > c.Aperture.Float():
+			return false, nil
+		case comp == 2 && fnum < c.Aperture.Float():
+			return false, nil
+		}
+	case strings.Contains(rule, "iso"):
+		rule = strings.Replace(rule, "iso", "", 1)
+		iso, err := strconv.ParseFloat(rule, 32)
+		if err != nil {
+			return false, fmt.Errorf("invalid iso value: '%s'", rule)
+		}
+		switch {
+		case comp == 0 && iso != float64(c.ISO):
+			return false, nil
+		case comp == 1 && iso > float64(c.ISO):
+			return false, nil
+		case comp == 2 && iso < float64(c.ISO):
+			return false, nil
+		}
+	case strings.Contains(rule, "s"):
+		rule = strings.Replace(rule, "s", "", 1)
+		p := strings.SplitN(rule, "/", 2)
+		if len(p) > 2 {
+			return false, fmt.Errorf("invalid shutter speed value: '%s'", rule)
+		}
+
+		nom, err := strconv.ParseFloat(p[0], 32)
+		if err != nil {
+			return false, fmt.Errorf("invalid shutters speed value: '%s'", rule)
+		}
+		denom := 1.0
+		if len(p) == 2 {
+			denom, err = strconv.ParseFloat(p[1], 32)
+			if err != nil {
+				return false, fmt.Errorf("invalid shutters speed value: '%s'", rule)
+			}
+		}
+
+		ss := nom / denom
+		switch {
+		case comp == 0 && math.Abs(ss-c.ShutterSpeed.Float()) > 1.0/256000:
+			return false, nil
+		case comp == 1 && ss < c.ShutterSpeed.Float():
+			return false, nil
+		case comp == 2 && ss > c.ShutterSpeed.Float():
+			return false, nil
+		}
+	case strings.Contains(rule, "mm"):
+		rule = strings.Replace(rule, "mm", "", 1)
+		fl, err := strconv.ParseFloat(rule, 32)
+		if err != nil {
+			return false, fmt.Errorf("invalid focal length value: '%s'", rule)
+		}
+		switch {
+		case comp == 0 && fl != c.FocalLength.Float():
+			return false, nil
+		case comp == 1 && fl > c.FocalLength.Float():
+			return false, nil
+		case comp == 2 && fl < c.FocalLength.Float():
+			return false, nil
+		}
+	default:
+		return false, fmt.Errorf("invalid exposure rule: '%s'", rule)
+	}
+
+	return true, nil
+}
+
+// PathTemplateFile holds the path template of a collection.
+const PathTemplateFile = ".path-template"
+
+func readPathTemplate(file string) (string, error) {
+	d, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return importer.DefaultPathTemplate, nil
+		}
+		return "", err
+	}
+	for _, l := range strings.Split(string(d), "\n") {
+		l = strings.TrimSpace(l)
+		if l != "" && l[0] != '#' {
+			return l, nil
+		}
+	}
+	return importer.DefaultPathTemplate, nil
+}
+
 func filterString(s string, filter []string) bool {
 	lc := strings.ToLower(s)
 	for i, p := range filter {
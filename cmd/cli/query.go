@@ -0,0 +1,419 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frizinak/photos/cmd/flags"
+	"github.com/frizinak/photos/importer"
+	"github.com/frizinak/photos/meta"
+	"github.com/frizinak/photos/query"
+	"github.com/frizinak/photos/tags"
+)
+
+// Queries is a set of saved named queries.
+type Queries map[string]string
+
+func (q Queries) Names() []string {
+	l := make([]string, 0, len(q))
+	for n := range q {
+		l = append(l, n)
+	}
+	sort.Strings(l)
+	return l
+}
+
+func (q Queries) lookup(name string) (query.Node, error) {
+	s, ok := q[name]
+	if !ok {
+		return nil, fmt.Errorf("no saved query named '%s'", name)
+	}
+	n, err := query.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("saved query @%s: %w", name, err)
+	}
+	return n, nil
+}
+
+// loadQueries reads a file of 'name query' lines, same format as photos.conf.
+func loadQueries(file string) (Queries, error) {
+	q := make(Queries)
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return q, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		t := strings.TrimSpace(s.Text())
+		if t == "" || t[0] == '#' {
+			continue
+		}
+		p := strings.SplitN(t, " ", 2)
+		if len(p) != 2 {
+			return q, fmt.Errorf("invalid saved query '%s' in '%s'", t, file)
+		}
+		q[p[0]] = strings.TrimSpace(p[1])
+	}
+
+	return q, s.Err()
+}
+
+func saveQueries(file string, q Queries) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, n := range q.Names() {
+		fmt.Fprintf(w, "%s %s\n", n, q[n])
+	}
+	err = w.Flush()
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func validQueryName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\n\"()@") {
+		return fmt.Errorf("invalid query name '%s'", name)
+	}
+	return nil
+}
+
+var queryBool = map[string]struct{}{
+	flags.Undeleted:      {},
+	flags.Deleted:        {},
+	flags.Updated:        {},
+	flags.Edited:         {},
+	flags.Unedited:       {},
+	flags.Rated:          {},
+	flags.Unrated:        {},
+	flags.Location:       {},
+	flags.NoLocation:     {},
+	flags.Photo:          {},
+	flags.Video:          {},
+	flags.StackPicksOnly: {},
+}
+
+// compileQuery turns the query AST into a MetaFilter. The operands of 'and'
+// and 'or' are ordered by weight so expensive predicates are evaluated last.
+func (f *Flags) compileQuery(n query.Node, imp *importer.Importer) (MetaFilterWeight, error) {
+	pair := func(l, r query.Node) (MetaFilterWeight, MetaFilterWeight, int, error) {
+		a, err := f.compileQuery(l, imp)
+		if err != nil {
+			return a, a, 0, err
+		}
+		b, err := f.compileQuery(r, imp)
+		if err != nil {
+			return a, b, 0, err
+		}
+		if b.Weight < a.Weight {
+			a, b = b, a
+		}
+		return a, b, b.Weight, nil
+	}
+
+	switch n := n.(type) {
+	case query.And:
+		a, b, w, err := pair(n.L, n.R)
+		return MetaFilterWeight{func(m meta.Meta, fl *importer.File) bool {
+			return a.MetaFilter(m, fl) && b.MetaFilter(m, fl)
+		}, w}, err
+	case query.Or:
+		a, b, w, err := pair(n.L, n.R)
+		return MetaFilterWeight{func(m meta.Meta, fl *importer.File) bool {
+			return a.MetaFilter(m, fl) || b.MetaFilter(m, fl)
+		}, w}, err
+	case query.Not:
+		a, err := f.compileQuery(n.N, imp)
+		return MetaFilterWeight{func(m meta.Meta, fl *importer.File) bool {
+			return !a.MetaFilter(m, fl)
+		}, a.Weight}, err
+	case query.Pred:
+		mf, err := f.predicate(n, imp)
+		if err != nil {
+			return mf, fmt.Errorf("%s: %w", n, err)
+		}
+		return mf, nil
+	case query.Ref:
+		return MetaFilterWeight{}, fmt.Errorf("unresolved query %s", n)
+	}
+
+	return MetaFilterWeight{}, fmt.Errorf("unknown query node %T", n)
+}
+
+func compare(op query.Op, a, b float64) bool {
+	switch op {
+	case query.OpMatch, query.OpEQ:
+		return a == b
+	case query.OpNE:
+		return a != b
+	case query.OpGT:
+		return a > b
+	case query.OpGE:
+		return a >= b
+	case query.OpLT:
+		return a < b
+	case query.OpLE:
+		return a <= b
+	}
+	return false
+}
+
+// timeRange returns [lo, hi) for a Y, Y-m, Y-m-d or Y-m-d H:M value.
+func timeRange(v string) (lo, hi time.Time, err error) {
+	formats := []struct {
+		f   string
+		add func(time.Time) time.Time
+	}{
+		{"2006-01-02 15:04", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+		{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+		{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+		{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+	}
+	for _, f := range formats {
+		lo, err = time.ParseInLocation(f.f, v, time.Local)
+		if err == nil {
+			return lo, f.add(lo), nil
+		}
+	}
+	return lo, hi, fmt.Errorf("invalid time '%s' [Y(-m(-d( H:M)))]", v)
+}
+
+// number parses v ignoring the given (case insensitive) pre- and suffixes,
+// a fraction like 1/200 is allowed.
+func number(v string, trim ...string) (float64, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	for _, t := range trim {
+		v = strings.TrimPrefix(strings.TrimSuffix(v, t), t)
+	}
+	p := strings.SplitN(v, "/", 2)
+	n, err := strconv.ParseFloat(p[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number '%s'", v)
+	}
+	if len(p) == 2 {
+		d, err := strconv.ParseFloat(p[1], 64)
+		if err != nil || d == 0 {
+			return 0, fmt.Errorf("invalid number '%s'", v)
+		}
+		n /= d
+	}
+	return n, nil
+}
+
+func (f *Flags) predicate(p query.Pred, imp *importer.Importer) (MetaFilterWeight, error) {
+	w := func(mf MetaFilter) (MetaFilterWeight, error) { return MetaFilterWeight{mf, 0}, nil }
+	none := MetaFilterWeight{}
+
+	if p.Op == query.OpNone {
+		if _, ok := queryBool[p.Field]; !ok {
+			return none, fmt.Errorf("unknown predicate, expected one of: %s", strings.Join(queryFields(), ", "))
+		}
+		_f, _mf, weight, err := f.boolFilter(p.Field, imp)
+		if _f != nil {
+			_mf = func(m meta.Meta, fl *importer.File) bool { return _f(fl) }
+		}
+		return MetaFilterWeight{_mf, weight}, err
+	}
+
+	match := func(cb func(m meta.Meta, fl *importer.File) bool) (MetaFilterWeight, error) {
+		not := false
+		switch p.Op {
+		case query.OpMatch, query.OpEQ:
+		case query.OpNE:
+			not = !not
+		default:
+			return none, fmt.Errorf("operator %s not supported for %s", p.Op, p.Field)
+		}
+		return w(func(m meta.Meta, fl *importer.File) bool { return cb(m, fl) != not })
+	}
+	glob := func(v string) []string { return strings.Split(strings.ToLower(v), "*") }
+	num := func(trim []string, cb func(c *tags.CameraInfo) float64) (MetaFilterWeight, error) {
+		v, err := number(p.Value, trim...)
+		if err != nil {
+			return none, err
+		}
+		return w(func(m meta.Meta, fl *importer.File) bool {
+			return m.CameraInfo != nil && compare(p.Op, cb(m.CameraInfo), v)
+		})
+	}
+
+	switch p.Field {
+	case "rating":
+		r, err := strconv.Atoi(p.Value)
+		if err != nil || r < 0 || r > 5 {
+			return none, fmt.Errorf("invalid rating '%s'", p.Value)
+		}
+		return w(func(m meta.Meta, fl *importer.File) bool {
+			return compare(p.Op, float64(m.Rating), float64(r))
+		})
+
+	case "tag":
+		if p.Value == "-" {
+			return match(func(m meta.Meta, fl *importer.File) bool { return len(m.Tags) == 0 })
+		}
+		g := glob(p.Value)
+		return match(func(m meta.Meta, fl *importer.File) bool {
+			for _, t := range m.Tags {
+				if filterString(t, g) {
+					return true
+				}
+			}
+			return false
+		})
+
+	case "camera", "lens":
+		g := glob(p.Value)
+		lens := p.Field == "lens"
+		return match(func(m meta.Meta, fl *importer.File) bool {
+			if m.CameraInfo == nil {
+				return false
+			}
+			d := m.CameraInfo.Device
+			if lens {
+				d = m.CameraInfo.Lens
+			}
+			return filterString(fmt.Sprintf("%s %s", d.Make, d.Model), g)
+		})
+
+	case "file":
+		g := glob(p.Value)
+		return match(func(m meta.Meta, fl *importer.File) bool {
+			return filterString(fl.Filename(), g)
+		})
+
+	case "ext":
+		ext := "." + strings.TrimLeft(strings.ToLower(p.Value), ".")
+		return match(func(m meta.Meta, fl *importer.File) bool {
+			return ext == strings.ToLower(filepath.Ext(fl.BaseFilename()))
+		})
+
+	case "location":
+		g := glob(p.Value)
+		return match(func(m meta.Meta, fl *importer.File) bool {
+			l := m.Location
+			return l != nil && (filterString(l.Name, g) || filterString(l.Address, g))
+		})
+
+	case "date", "since", "until":
+		lo, hi, err := timeRange(p.Value)
+		if err != nil {
+			return none, err
+		}
+		op := p.Op
+		switch {
+		case p.Field == "since" && op == query.OpMatch:
+			op = query.OpGE
+		case p.Field == "until" && op == query.OpMatch:
+			op = query.OpLE
+		case p.Field != "date":
+			return none, fmt.Errorf("use %s:<time>", p.Field)
+		}
+		return w(func(m meta.Meta, fl *importer.File) bool {
+			t := m.CreatedTime()
+			in := !t.Before(lo) && t.Before(hi)
+			switch op {
+			case query.OpMatch, query.OpEQ:
+				return in
+			case query.OpNE:
+				return !in
+			case query.OpGT:
+				return !t.Before(hi)
+			case query.OpGE:
+				return !t.Before(lo)
+			case query.OpLT:
+				return t.Before(lo)
+			case query.OpLE:
+				return t.Before(hi)
+			}
+			return false
+		})
+
+	case "aperture":
+		return num([]string{"f/", "f"}, func(c *tags.CameraInfo) float64 { return c.Aperture.Float() })
+	case "iso":
+		return num(nil, func(c *tags.CameraInfo) float64 { return float64(c.ISO) })
+	case "focal":
+		return num([]string{"mm"}, func(c *tags.CameraInfo) float64 { return c.FocalLength.Float() })
+	case "shutter":
+		v, err := number(p.Value, "s")
+		if err != nil {
+			return none, err
+		}
+		return w(func(m meta.Meta, fl *importer.File) bool {
+			if m.CameraInfo == nil {
+				return false
+			}
+			ss := m.CameraInfo.ShutterSpeed.Float()
+			if math.Abs(ss-v) <= 1.0/256000 {
+				ss = v
+			}
+			return compare(p.Op, ss, v)
+		})
+
+	case "album":
+		albums, err := imp.Albums()
+		if err != nil {
+			return none, err
+		}
+		g := glob(p.Value)
+		sums := make(map[string]struct{})
+		for _, a := range albums {
+			if !filterString(a.Name, g) {
+				continue
+			}
+			for _, s := range a.Items {
+				sums[s] = struct{}{}
+			}
+		}
+		return match(func(m meta.Meta, fl *importer.File) bool {
+			_, ok := sums[m.Checksum]
+			return ok
+		})
+
+	case "exposure":
+		if _, err := exposureRule(p.Value, &tags.CameraInfo{}); err != nil {
+			return none, err
+		}
+		return match(func(m meta.Meta, fl *importer.File) bool {
+			if m.CameraInfo == nil {
+				return false
+			}
+			ok, _ := exposureRule(p.Value, m.CameraInfo)
+			return ok
+		})
+	}
+
+	return none, fmt.Errorf("unknown field, expected one of: %s", strings.Join(queryFields(), ", "))
+}
+
+func queryFields() []string {
+	bools := make([]string, 0, len(queryBool))
+	for n := range queryBool {
+		bools = append(bools, n)
+	}
+	sort.Strings(bools)
+	return append([]string{
+		"rating", "tag", "camera", "lens", "file", "ext", "location", "album",
+		"date", "since", "until", "aperture", "iso", "focal", "shutter", "exposure",
+	}, bools...)
+}
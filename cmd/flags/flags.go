@@ -29,6 +29,7 @@ const (
 	NoLocation         = "nolocation"
 	Photo              = "photo"
 	Video              = "video"
+	StackPicksOnly     = "stack-picks-only"
 	GT                 = "gt"
 	LT                 = "lt"
 	Camera             = "camera"
@@ -40,6 +41,14 @@ const (
 	Until              = "until"
 	Tags               = "tag"
 	Checksum           = "sum"
+	Verify             = "verify"
+	EraseSource        = "erase-source"
+	TetherWindow       = "tether-window"
+	WatchSettle        = "settle"
+	SimilarDistance    = "distance"
+	SimilarRate        = "similar-rate"
+	EmbeddedPreview    = "embedded-previews"
+	EditedPreviews     = "edited-previews"
 	ImportJPEG         = "import-jpegs"
 	Sizes              = "sizes"
 	RawDir             = "raws"
@@ -56,31 +65,65 @@ const (
 	Verbose            = "v"
 	Editor             = "editor"
 	TimeOverride       = "force-time"
+	GalleryDir         = "gallery"
+	PreviewCache       = "preview-cache"
+	PreviewCacheSize   = "preview-cache-size"
+	Listen             = "listen"
+	Format             = "format"
+	Query              = "q"
+	SaveQuery          = "save-query"
+	Album              = "album"
+	AlbumTitle         = "album-title"
+	AlbumCover         = "album-cover"
+	AlbumDir           = "albums"
+	PathTemplate       = "path-template"
 )
 
 const (
-	ActionImport       = "import"
-	ActionShow         = "show"
-	ActionShowJPEGs    = "show-jpegs"
-	ActionShowPreviews = "show-previews"
-	ActionShowLinks    = "show-links"
-	ActionShowTags     = "show-tags"
-	ActionInfo         = "info"
-	ActionLink         = "link"
-	ActionPreviews     = "previews"
-	ActionRate         = "rate"
-	ActionEdit         = "edit"
-	ActionSyncMeta     = "sync-meta"
-	ActionRewriteMeta  = "rewrite-meta"
-	ActionConvert      = "convert"
-	ActionJPEGFixup    = "jpeg-fixup"
-	ActionExec         = "exec"
-	ActionCleanup      = "cleanup"
-	ActionTagsRemove   = "remove-tags"
-	ActionTagsAdd      = "add-tags"
-	ActionGPhotos      = "gphotos"
-	ActionGLocation    = "glocation"
-	ActionVersion      = "version"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+const (
+	ActionImport        = "import"
+	ActionVerifyImport  = "verify-import"
+	ActionTether        = "tether"
+	ActionWatch         = "watch"
+	ActionStack         = "stack"
+	ActionSimilar       = "similar"
+	ActionShow          = "show"
+	ActionShowJPEGs     = "show-jpegs"
+	ActionShowPreviews  = "show-previews"
+	ActionShowLinks     = "show-links"
+	ActionShowTags      = "show-tags"
+	ActionShowQueries   = "show-queries"
+	ActionShowPipelines = "show-pipelines"
+	ActionInfo          = "info"
+	ActionLink          = "link"
+	ActionRelink        = "relink"
+	ActionPreviews      = "previews"
+	ActionRate          = "rate"
+	ActionServe         = "serve"
+	ActionEdit          = "edit"
+	ActionSyncMeta      = "sync-meta"
+	ActionRewriteMeta   = "rewrite-meta"
+	ActionIndex         = "index"
+	ActionConvert       = "convert"
+	ActionJPEGFixup     = "jpeg-fixup"
+	ActionExec          = "exec"
+	ActionCleanup       = "cleanup"
+	ActionUndo          = "undo"
+	ActionEmptyTrash    = "empty-trash"
+	ActionTagsRemove    = "remove-tags"
+	ActionTagsAdd       = "add-tags"
+	ActionGPhotos       = "gphotos"
+	ActionGLocation     = "glocation"
+	ActionGallery       = "export-gallery"
+	ActionAlbumAdd      = "add-to-album"
+	ActionAlbumRemove   = "remove-from-album"
+	ActionShowAlbums    = "show-albums"
+	ActionVersion       = "version"
 )
 
 var (
@@ -97,6 +140,7 @@ var (
 		NoLocation:         {},
 		Photo:              {},
 		Video:              {},
+		StackPicksOnly:     {},
 		GT:                 {},
 		LT:                 {},
 		Camera:             {},
@@ -124,30 +168,58 @@ var (
 		Verbose:            {},
 		Editor:             {},
 		TimeOverride:       {},
+		GalleryDir:         {},
+		PreviewCache:       {},
+		PreviewCacheSize:   {},
+		Listen:             {},
+		Format:             {},
+		Query:              {},
+		SaveQuery:          {},
+		Album:              {},
+		AlbumTitle:         {},
+		AlbumCover:         {},
+		AlbumDir:           {},
+		PathTemplate:       {},
 	}
 
 	AllActions = map[string]struct{}{
-		ActionImport:       {},
-		ActionShow:         {},
-		ActionShowPreviews: {},
-		ActionShowJPEGs:    {},
-		ActionShowLinks:    {},
-		ActionShowTags:     {},
-		ActionInfo:         {},
-		ActionLink:         {},
-		ActionPreviews:     {},
-		ActionRate:         {},
-		ActionEdit:         {},
-		ActionSyncMeta:     {},
-		ActionRewriteMeta:  {},
-		ActionConvert:      {},
-		ActionJPEGFixup:    {},
-		ActionExec:         {},
-		ActionCleanup:      {},
-		ActionTagsRemove:   {},
-		ActionTagsAdd:      {},
-		ActionGPhotos:      {},
-		ActionGLocation:    {},
-		ActionVersion:      {},
+		ActionImport:        {},
+		ActionVerifyImport:  {},
+		ActionTether:        {},
+		ActionWatch:         {},
+		ActionStack:         {},
+		ActionSimilar:       {},
+		ActionShow:          {},
+		ActionShowPreviews:  {},
+		ActionShowJPEGs:     {},
+		ActionShowLinks:     {},
+		ActionShowTags:      {},
+		ActionShowQueries:   {},
+		ActionShowPipelines: {},
+		ActionInfo:          {},
+		ActionLink:          {},
+		ActionRelink:        {},
+		ActionPreviews:      {},
+		ActionRate:          {},
+		ActionServe:         {},
+		ActionEdit:          {},
+		ActionSyncMeta:      {},
+		ActionRewriteMeta:   {},
+		ActionIndex:         {},
+		ActionConvert:       {},
+		ActionJPEGFixup:     {},
+		ActionExec:          {},
+		ActionCleanup:       {},
+		ActionUndo:          {},
+		ActionEmptyTrash:    {},
+		ActionTagsRemove:    {},
+		ActionTagsAdd:       {},
+		ActionGPhotos:       {},
+		ActionGLocation:     {},
+		ActionGallery:       {},
+		ActionAlbumAdd:      {},
+		ActionAlbumRemove:   {},
+		ActionShowAlbums:    {},
+		ActionVersion:       {},
 	}
 )
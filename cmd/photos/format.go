@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frizinak/photos/cmd/flags"
+	"github.com/frizinak/photos/importer"
+)
+
+// csvSep separates list values in a single csv column.
+const csvSep = "|"
+
+type row interface {
+	header() []string
+	csv() []string
+}
+
+type location struct {
+	Lat     float64 `json:"lat"`
+	Lng     float64 `json:"lng"`
+	Name    string  `json:"name"`
+	Address string  `json:"address"`
+}
+
+type camera struct {
+	Make         string  `json:"make"`
+	Model        string  `json:"model"`
+	LensMake     string  `json:"lens_make"`
+	LensModel    string  `json:"lens_model"`
+	Aperture     float64 `json:"aperture"`
+	ShutterSpeed string  `json:"shutter_speed"`
+	FocalLength  float64 `json:"focal_length"`
+	ISO          int     `json:"iso"`
+}
+
+type converted struct {
+	Path string `json:"path"`
+	Size int    `json:"size"`
+	Hash string `json:"hash"`
+}
+
+type record struct {
+	Raw             string      `json:"raw"`
+	Filename        string      `json:"filename"`
+	RealFilename    string      `json:"real_filename"`
+	Checksum        string      `json:"checksum"`
+	Size            int64       `json:"size"`
+	Created         string      `json:"created"`
+	CreatedOverride bool        `json:"created_override"`
+	Deleted         bool        `json:"deleted"`
+	Rating          uint8       `json:"rating"`
+	Label           string      `json:"label"`
+	Tags            []string    `json:"tags"`
+	Pair            string      `json:"pair"`
+	Stack           string      `json:"stack"`
+	Pick            bool        `json:"pick"`
+	PHash           string      `json:"phash"`
+	Location        *location   `json:"location"`
+	Camera          *camera     `json:"camera"`
+	Converted       []converted `json:"converted"`
+	Links           []string    `json:"links"`
+	Preview         string      `json:"preview"`
+}
+
+func newRecord(imp *importer.Importer, jpegDir string, f *FileMeta) (record, error) {
+	m := f.m
+	raw, err := filepath.Abs(f.f.Path())
+	if err != nil {
+		return record{}, err
+	}
+
+	r := record{
+		Raw:             raw,
+		Filename:        f.f.BaseFilename(),
+		RealFilename:    m.RealFilename,
+		Checksum:        m.Checksum,
+		Size:            m.Size,
+		Created:         m.CreatedTime().Format(time.RFC3339),
+		CreatedOverride: m.CreatedOverride,
+		Deleted:         m.Deleted,
+		Rating:          m.Rating,
+		Label:           m.Label,
+		Tags:            m.Tags.Unique(),
+		Pair:            m.Pair,
+		Stack:           m.Stack,
+		Pick:            m.Pick,
+		Converted:       make([]converted, 0, len(m.Conv)),
+	}
+	if r.Tags == nil {
+		r.Tags = []string{}
+	}
+	if m.PHashed {
+		r.PHash = fmt.Sprintf("%016x", m.PHash)
+	}
+
+	if l := m.Location; l != nil {
+		r.Location = &location{l.Lat, l.Lng, l.Name, l.Address}
+	}
+
+	if c := m.CameraInfo; c != nil {
+		r.Camera = &camera{
+			Make:         c.Make,
+			Model:        c.Model,
+			LensMake:     c.Lens.Make,
+			LensModel:    c.Lens.Model,
+			Aperture:     c.Aperture.Float(),
+			ShutterSpeed: strings.TrimSuffix(c.ShutterSpeed.String(), "s"),
+			FocalLength:  c.FocalLength.Float(),
+			ISO:          int(c.ISO),
+		}
+	}
+
+	for p, c := range m.Conv {
+		p, err := filepath.Abs(filepath.Join(jpegDir, p))
+		if err != nil {
+			return r, err
+		}
+		r.Converted = append(r.Converted, converted{p, c.Size, c.Hash})
+	}
+	sort.Slice(r.Converted, func(i, j int) bool { return r.Converted[i].Path < r.Converted[j].Path })
+
+	links, err := imp.FindLinks(f.f)
+	if err != nil {
+		return r, err
+	}
+	r.Links = make([]string, len(links))
+	for i := range links {
+		if r.Links[i], err = filepath.Abs(links[i]); err != nil {
+			return r, err
+		}
+	}
+
+	p := imp.PreviewFile(f.f, importer.PreviewScreen)
+	if _, err := os.Stat(p); err == nil {
+		if r.Preview, err = filepath.Abs(p); err != nil {
+			return r, err
+		}
+	} else if !os.IsNotExist(err) {
+		return r, err
+	}
+
+	return r, nil
+}
+
+func (r record) header() []string {
+	return []string{
+		"raw", "filename", "real_filename", "checksum", "size",
+		"created", "created_override", "deleted", "rating", "label", "tags",
+		"pair", "stack", "pick", "phash",
+		"lat", "lng", "location", "address",
+		"make", "model", "lens_make", "lens_model",
+		"aperture", "shutter_speed", "focal_length", "iso",
+		"converted", "links", "preview",
+	}
+}
+
+func (r record) csv() []string {
+	float := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+	var l location
+	var c camera
+	var lat, lng, aperture, focal, iso string
+	if r.Location != nil {
+		l = *r.Location
+		lat, lng = float(l.Lat), float(l.Lng)
+	}
+	if r.Camera != nil {
+		c = *r.Camera
+		aperture, focal, iso = float(c.Aperture), float(c.FocalLength), strconv.Itoa(c.ISO)
+	}
+
+	conv := make([]string, len(r.Converted))
+	for i, c := range r.Converted {
+		conv[i] = c.Path
+	}
+
+	return []string{
+		r.Raw, r.Filename, r.RealFilename, r.Checksum, strconv.FormatInt(r.Size, 10),
+		r.Created, strconv.FormatBool(r.CreatedOverride), strconv.FormatBool(r.Deleted),
+		strconv.Itoa(int(r.Rating)), r.Label, strings.Join(r.Tags, csvSep),
+		r.Pair, r.Stack, strconv.FormatBool(r.Pick), r.PHash,
+		lat, lng, l.Name, l.Address,
+		c.Make, c.Model, c.LensMake, c.LensModel,
+		aperture, c.ShutterSpeed, focal, iso,
+		strings.Join(conv, csvSep), strings.Join(r.Links, csvSep), r.Preview,
+	}
+}
+
+// similarRecord is a record and the index of the cluster of near-duplicates
+// it is part of.
+type similarRecord struct {
+	Cluster int `json:"cluster"`
+	record
+}
+
+func (r similarRecord) header() []string { return append([]string{"cluster"}, r.record.header()...) }
+func (r similarRecord) csv() []string {
+	return append([]string{strconv.Itoa(r.Cluster)}, r.record.csv()...)
+}
+
+type tagRecord struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+func (t tagRecord) header() []string { return []string{"tag", "count"} }
+func (t tagRecord) csv() []string    { return []string{t.Tag, strconv.Itoa(t.Count)} }
+
+type output struct {
+	format string
+	w      *bufio.Writer
+	csv    *csv.Writer
+	n      int
+}
+
+func newOutput(format string, w io.Writer) *output {
+	o := &output{format: format, w: bufio.NewWriter(w)}
+	if format == flags.FormatCSV {
+		o.csv = csv.NewWriter(o.w)
+	}
+	return o
+}
+
+func (o *output) write(r row) error {
+	defer func() { o.n++ }()
+	switch o.format {
+	case flags.FormatCSV:
+		if o.n == 0 {
+			if err := o.csv.Write(r.header()); err != nil {
+				return err
+			}
+		}
+		return o.csv.Write(r.csv())
+	case flags.FormatJSON, flags.FormatNDJSON:
+		d, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if o.format == flags.FormatJSON {
+			sep := ",\n"
+			if o.n == 0 {
+				sep = "[\n"
+			}
+			o.w.WriteString(sep)
+		}
+		o.w.Write(d)
+		if o.format == flags.FormatNDJSON {
+			o.w.WriteByte('\n')
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported format '%s'", o.format)
+}
+
+func (o *output) close() error {
+	switch o.format {
+	case flags.FormatCSV:
+		o.csv.Flush()
+		if err := o.csv.Error(); err != nil {
+			return err
+		}
+	case flags.FormatJSON:
+		if o.n == 0 {
+			o.w.WriteString("[")
+		}
+		o.w.WriteString("\n]\n")
+	}
+	return o.w.Flush()
+}
@@ -14,24 +14,31 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/frizinak/phodo/phodo"
+	"github.com/frizinak/photos/album"
 	"github.com/frizinak/photos/cmd/cli"
 	"github.com/frizinak/photos/cmd/flags"
+	"github.com/frizinak/photos/gallery"
 	"github.com/frizinak/photos/gphotos"
 	"github.com/frizinak/photos/gtimeline"
 	"github.com/frizinak/photos/importer"
 	"github.com/frizinak/photos/importer/fs"
 	"github.com/frizinak/photos/importer/gphoto2"
 	"github.com/frizinak/photos/importer/libgphoto2"
+	"github.com/frizinak/photos/journal"
 	"github.com/frizinak/photos/meta"
 	"github.com/frizinak/photos/rate"
+	"github.com/frizinak/photos/serve"
 	"github.com/frizinak/version"
 )
 
@@ -55,10 +62,10 @@ func (f FileMetas) Less(i, j int) bool {
 	return f[i].d.Before(f[j].d)
 }
 
-func combine(files importer.Files) (FileMetas, error) {
+func combine(imp *importer.Importer, files importer.Files) (FileMetas, error) {
 	m := make(FileMetas, len(files))
 	for i, f := range files {
-		met, err := importer.GetMeta(f)
+		met, err := imp.Meta(f)
 		if err != nil {
 			return nil, err
 		}
@@ -88,6 +95,9 @@ func main() {
 		flag.CollectionDir(),
 		flag.JPEGDir(),
 	)
+	imp.SetAlbumDir(flag.AlbumDir())
+	imp.SetPathTemplate(flag.PathTemplate())
+	imp.SetPreviewCache(flag.PreviewCache(), flag.PreviewCacheSize())
 
 	var filter func(f *importer.File) bool
 	all := func(it func(f *importer.File) (bool, error)) {
@@ -157,11 +167,56 @@ func main() {
 
 	allMeta := func() FileMetas {
 		all := allList()
-		l, err := combine(all)
+		l, err := combine(imp, all)
 		flag.Exit(err)
 		return l
 	}
 
+	structured := func(include func(*record) bool) {
+		list := allMeta()
+		sort.Sort(list)
+		out := newOutput(flag.Format(), os.Stdout)
+		for _, f := range list {
+			r, err := newRecord(imp, flag.JPEGDir(), f)
+			flag.Exit(err)
+			if include != nil && !include(&r) {
+				continue
+			}
+			flag.Exit(out.write(r))
+		}
+		flag.Exit(out.close())
+	}
+
+	albumEdit := func(edit func(a *album.Album, list FileMetas)) album.Album {
+		name := flag.Album()
+		if name == "" {
+			flag.Exit(fmt.Errorf("please specify an album with -%s", flags.Album))
+		}
+		a, err := imp.Album(name)
+		if os.IsNotExist(err) {
+			a, err = album.New(name), nil
+		}
+		flag.Exit(err)
+
+		prev := imp.AlbumLinkDir(a)
+		if t := flag.AlbumTitle(); t != "" {
+			a.Title = t
+		}
+		edit(&a, allMeta())
+		flag.Exit(imp.SaveAlbum(a))
+
+		if dir := imp.AlbumLinkDir(a); dir != prev {
+			if _, err := os.Stat(dir); os.IsNotExist(err) {
+				os.Rename(prev, dir)
+			}
+		}
+
+		sums, err := imp.Checksums()
+		flag.Exit(err)
+		flag.Exit(imp.MaterializeAlbum(a, sums))
+		return a
+	}
+
 	type workCB func() error
 	type workCheckCB func(*importer.File) (workCB, error)
 
@@ -256,17 +311,58 @@ func main() {
 	work := _work(true)
 	workNoProgress := _work(false)
 
+	importExts := func() (string, []string) {
+		exts := make([]string, 0)
+		exts = imp.RawExtList(exts)
+		exts = imp.VideoExtList(exts)
+		n := "raw"
+		if flag.ImportJPEG() {
+			n = "all"
+			exts = imp.ImageExtList(exts)
+		}
+		return n, exts
+	}
+
+	registerCamera := func(exts []string) {
+		var gp importer.Backend = libgphoto2.New(exts)
+		n := "libgphoto2"
+		if ok, _ := gp.Available(); !ok {
+			gp = gphoto2.New(exts)
+			n = "gphoto2"
+		}
+		importer.Register(n, gp)
+	}
+
+	linkAndPreview := func(f *importer.File) error {
+		if err := imp.Link(f); err != nil {
+			return err
+		}
+		if ex, can := imp.HasPreview(f); !ex && can {
+			if err := imp.EnsurePreview(f); err != nil {
+				l.Println("WARN", f.Filename(), err)
+			}
+		}
+		return nil
+	}
+
+	interrupt := func() (<-chan struct{}, func()) {
+		stop := make(chan struct{})
+		var stopOnce sync.Once
+		halt := func() { stopOnce.Do(func() { close(stop) }) }
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		go func() {
+			<-sig
+			signal.Stop(sig)
+			halt()
+		}()
+		return stop, halt
+	}
+
 	cmds := map[string]func(){
 		flags.ActionImport: func() {
 			l.Println("importing")
-			exts := make([]string, 0)
-			exts = imp.RawExtList(exts)
-			exts = imp.VideoExtList(exts)
-			n := "raw"
-			if flag.ImportJPEG() {
-				n = "all"
-				exts = imp.ImageExtList(exts)
-			}
+			n, exts := importExts()
 
 			fsi := false
 			for _, path := range flag.SourceDirs() {
@@ -278,27 +374,202 @@ func main() {
 			}
 
 			if !fsi {
-				var gp importer.Backend = libgphoto2.New(exts)
-				n = "libgphoto2"
-				if ok, _ := gp.Available(); !ok {
-					gp = gphoto2.New(exts)
-					n = "gphoto2"
+				registerCamera(exts)
+			}
+
+			erase := flag.EraseSource() && !flag.Checksum()
+			if erase && !flag.Yes() {
+				fmt.Print("Delete files from the source once imported and verified? [y/N]: ")
+				answer := ask()
+				erase = answer == "y" || answer == "Y"
+			}
+
+			flag.Exit(imp.Import(flag.Checksum(), flag.Verify(), erase, progress, flag.TimeOverride()))
+			progressDone()
+		},
+		flags.ActionTether: func() {
+			l.Println("tethering, ctrl-c to stop")
+			_, exts := importExts()
+			registerCamera(exts)
+
+			stop, halt := interrupt()
+
+			var raterSem sync.Mutex
+			var rater *rate.Rater
+			var captured importer.Files
+			first := make(chan struct{}, 1)
+			shot := func(f *importer.File) error {
+				l.Printf("captured %s", f.Path())
+				if err := linkAndPreview(f); err != nil {
+					return err
 				}
-				importer.Register(n, gp)
+
+				if !flag.TetherWindow() {
+					return nil
+				}
+				raterSem.Lock()
+				defer raterSem.Unlock()
+				if rater != nil {
+					rater.Add(f)
+					return nil
+				}
+				captured = append(captured, f)
+				select {
+				case first <- struct{}{}:
+				default:
+				}
+				return nil
+			}
+
+			errs := make(chan error, 1)
+			go func() { errs <- imp.Tether(flag.Verify(), shot, stop) }()
+			if !flag.TetherWindow() {
+				flag.Exit(<-errs)
+				return
+			}
+
+			select {
+			case <-first:
+			case err := <-errs:
+				flag.Exit(err)
+				return
+			}
+
+			raterSem.Lock()
+			r, err := rate.New(l, captured, imp, editor)
+			flag.Exit(err)
+			r.Follow(true)
+			rater = r
+			raterSem.Unlock()
+
+			err = rater.Run()
+			halt()
+			flag.Exit(err)
+			flag.Exit(<-errs)
+		},
+		flags.ActionWatch: func() {
+			dirs := flag.SourceDirs()
+			if len(dirs) == 0 {
+				flag.Exit(fmt.Errorf("-%s is required for -action %s", flags.SourceDir, flags.ActionWatch))
+			}
+
+			erase := flag.EraseSource() && !flag.Checksum()
+			if erase && !flag.Yes() {
+				fmt.Print("Delete files from the source once imported and verified? [y/N]: ")
+				answer := ask()
+				erase = answer == "y" || answer == "Y"
 			}
 
-			flag.Exit(imp.Import(flag.Checksum(), progress, flag.TimeOverride()))
+			l.Printf("watching %s, ctrl-c to stop", strings.Join(dirs, ", "))
+			_, exts := importExts()
+			stop, _ := interrupt()
+			w := fs.NewWatcher(dirs, true, exts, flag.WatchSettle())
+			flag.Exit(w.Watch(l, stop, func(files []*importer.File) error {
+				l.Printf("importing %d file(s)", len(files))
+				importer.Register("watch", fs.NewFiles(files))
+				var sem sync.Mutex
+				n := 0
+				err := imp.ImportFunc(
+					flag.Checksum(),
+					flag.Verify(),
+					erase,
+					func(int, int) {},
+					flag.TimeOverride(),
+					func(f *importer.File) error {
+						sem.Lock()
+						defer sem.Unlock()
+						n++
+						l.Printf("imported %s", f.Path())
+						return linkAndPreview(f)
+					},
+				)
+				if err != nil {
+					l.Println("ERR", err)
+				}
+				l.Printf("imported %d/%d file(s)", n, len(files))
+				return nil
+			}))
+		},
+		flags.ActionVerifyImport: func() {
+			l.Println("verifying import")
+			flag.Exit(imp.VerifyImport(progress))
 			progressDone()
 		},
+		flags.ActionStack: func() {
+			l.Println("stacking")
+			n, err := imp.Stack(allList(), importer.StackGap)
+			flag.Exit(err)
+			l.Printf("%d stacks", n)
+		},
+		flags.ActionSimilar: func() {
+			work(-1, func(f *importer.File) (workCB, error) {
+				if ex, _ := imp.HasPreview(f); !ex {
+					return nil, nil
+				}
+				return func() error {
+					_, err := imp.PHash(f)
+					return err
+				}, nil
+			})
+
+			clusters, err := imp.Similar(allList(), flag.SimilarDistance())
+			flag.Exit(err)
+			if len(clusters) == 0 {
+				l.Println("no near-duplicates found with given filters")
+				return
+			}
+
+			if flag.SimilarRate() {
+				for n, c := range clusters {
+					l.Printf("cluster %d/%d", n+1, len(clusters))
+					rater, err := rate.New(l, c, imp, editor)
+					flag.Exit(err)
+					flag.Exit(rater.Run())
+				}
+				return
+			}
+
+			if flag.Format() != "" {
+				out := newOutput(flag.Format(), os.Stdout)
+				for n, c := range clusters {
+					list, err := combine(imp, c)
+					flag.Exit(err)
+					for _, f := range list {
+						r, err := newRecord(imp, flag.JPEGDir(), f)
+						flag.Exit(err)
+						flag.Exit(out.write(similarRecord{n, r}))
+					}
+				}
+				flag.Exit(out.close())
+				return
+			}
+
+			for n, c := range clusters {
+				if n != 0 {
+					flag.Output("")
+				}
+				for _, f := range c {
+					flag.Output(f.Path())
+				}
+			}
+		},
 		flags.ActionShow: func() {
+			if flag.Format() != "" {
+				structured(nil)
+				return
+			}
 			all(func(f *importer.File) (bool, error) {
 				flag.Output(f.Path())
 				return true, nil
 			})
 		},
 		flags.ActionShowPreviews: func() {
+			if flag.Format() != "" {
+				structured(func(r *record) bool { return r.Preview != "" })
+				return
+			}
 			all(func(f *importer.File) (bool, error) {
-				p := importer.PreviewFile(f)
+				p := imp.PreviewFile(f, importer.PreviewScreen)
 				_, err := os.Stat(p)
 				if err != nil {
 					if os.IsNotExist(err) {
@@ -316,13 +587,30 @@ func main() {
 			})
 		},
 		flags.ActionShowJPEGs: func() {
-			list := allMeta()
-			sort.Sort(list)
 			sizes := flag.Sizes()
 			smap := make(map[int]struct{}, len(sizes))
 			for _, s := range sizes {
 				smap[s] = struct{}{}
 			}
+			if flag.Format() != "" {
+				structured(func(r *record) bool {
+					if len(sizes) == 0 {
+						return len(r.Converted) != 0
+					}
+					conv := make([]converted, 0, len(r.Converted))
+					for _, c := range r.Converted {
+						if _, ok := smap[c.Size]; ok {
+							conv = append(conv, c)
+						}
+					}
+					r.Converted = conv
+					return len(conv) != 0
+				})
+				return
+			}
+
+			list := allMeta()
+			sort.Sort(list)
 			for _, f := range list {
 				for jpg, conv := range f.m.Conv {
 					if len(sizes) != 0 {
@@ -340,6 +628,10 @@ func main() {
 			}
 		},
 		flags.ActionShowLinks: func() {
+			if flag.Format() != "" {
+				structured(func(r *record) bool { return len(r.Links) != 0 })
+				return
+			}
 			list := allMeta()
 			sort.Sort(list)
 			for _, f := range list {
@@ -354,16 +646,40 @@ func main() {
 				}
 			}
 		},
+		flags.ActionShowQueries: func() {
+			q := flag.Queries()
+			for _, n := range q.Names() {
+				flag.Output(fmt.Sprintf("@%s %s", n, q[n]))
+			}
+		},
+		flags.ActionShowPipelines: func() {
+			p := flag.Pipelines()
+			for _, n := range p.Names() {
+				flag.Output(fmt.Sprintf("%s %s", n, strings.Join(p[n], ",")))
+			}
+		},
 		flags.ActionShowTags: func() {
 			tags := make(meta.Tags, 0)
+			counts := make(map[string]int)
 			all(func(f *importer.File) (bool, error) {
-				m, err := importer.GetMeta(f)
+				m, err := imp.Meta(f)
 				if err != nil {
 					return false, err
 				}
+				for _, t := range m.Tags.Unique() {
+					counts[t]++
+				}
 				tags = append(tags, m.Tags...)
 				return true, nil
 			})
+			if flag.Format() != "" {
+				out := newOutput(flag.Format(), os.Stdout)
+				for _, t := range tags.Unique() {
+					flag.Exit(out.write(tagRecord{t, counts[t]}))
+				}
+				flag.Exit(out.close())
+				return
+			}
 			for _, t := range tags.Unique() {
 				flag.Output(t)
 			}
@@ -439,8 +755,82 @@ func main() {
 				return func() error { return imp.Link(f) }, nil
 			})
 			imp.ClearCache()
+
+			albums, err := imp.Albums()
+			flag.Exit(err)
+			if flag.Album() != "" {
+				a, err := imp.Album(flag.Album())
+				flag.Exit(err)
+				albums = []album.Album{a}
+			}
+			if len(albums) == 0 {
+				return
+			}
+			sums, err := imp.Checksums()
+			flag.Exit(err)
+			for _, a := range albums {
+				flag.Exit(imp.MaterializeAlbum(a, sums))
+			}
+		},
+		flags.ActionRelink: func() {
+			l.Printf("relinking to %s", flag.PathTemplate())
+			imp.ClearCache()
+			work(1, func(f *importer.File) (workCB, error) {
+				return func() error { return imp.Relink(f) }, nil
+			})
+			imp.ClearCache()
+			flag.Exit(imp.CleanLinkDirs())
+		},
+		flags.ActionAlbumAdd: func() {
+			a := albumEdit(func(a *album.Album, list FileMetas) {
+				sort.Sort(list)
+				sums := make([]string, 0, len(list))
+				for _, f := range list {
+					if f.m.Checksum != "" {
+						sums = append(sums, f.m.Checksum)
+					}
+				}
+				n := a.Add(sums...)
+				if flag.AlbumCover() && len(sums) != 0 {
+					a.Cover = sums[0]
+				}
+				l.Printf("added %d files to album %s", n, a.Name)
+			})
+			l.Printf("album %s has %d files", a.Name, len(a.Items))
+		},
+		flags.ActionAlbumRemove: func() {
+			albumEdit(func(a *album.Album, list FileMetas) {
+				sums := make([]string, 0, len(list))
+				for _, f := range list {
+					sums = append(sums, f.m.Checksum)
+				}
+				n := a.Remove(sums...)
+				l.Printf("removed %d files from album %s", n, a.Name)
+			})
+		},
+		flags.ActionShowAlbums: func() {
+			albums, err := imp.Albums()
+			flag.Exit(err)
+			for _, a := range albums {
+				flag.Output(fmt.Sprintf("%s\t%s\t%d", a.Name, a.Title, len(a.Items)))
+			}
 		},
 		flags.ActionPreviews: func() {
+			if flag.EditedPreviews() {
+				l.Println("refreshing previews of edits")
+				work(-1, func(f *importer.File) (workCB, error) {
+					stale, err := imp.MakeEditedPreviews(f, true)
+					if !stale || err != nil {
+						return nil, err
+					}
+					return func() error {
+						_, err := imp.MakeEditedPreviews(f, false)
+						return err
+					}, nil
+				})
+				return
+			}
+
 			l.Println("creating previews")
 			work(-1, func(f *importer.File) (workCB, error) {
 				ex, can := imp.HasPreview(f)
@@ -473,6 +863,23 @@ func main() {
 			flag.Exit(err)
 			flag.Exit(rater.Run())
 		},
+		flags.ActionServe: func() {
+			_list := allMeta()
+			sort.Sort(_list)
+			list := make(importer.Files, len(_list))
+			for i := range _list {
+				list[i] = _list[i].f
+			}
+
+			if len(list) == 0 {
+				l.Println("no files to serve with given filters")
+				return
+			}
+
+			srv, err := serve.New(l, list, imp)
+			flag.Exit(err)
+			flag.Exit(srv.ListenAndServe(flag.Listen()))
+		},
 		flags.ActionEdit: func() {
 			list := allMeta()
 			sort.Sort(list)
@@ -490,6 +897,10 @@ func main() {
 				return func() error { return imp.SyncMetaAndPP3(f) }, nil
 			})
 		},
+		flags.ActionIndex: func() {
+			l.Println("rebuilding meta index")
+			flag.Exit(imp.RebuildIndex())
+		},
 		flags.ActionRewriteMeta: func() {
 			l.Println("rewriting meta")
 			work(-1, func(f *importer.File) (workCB, error) {
@@ -501,7 +912,7 @@ func main() {
 		},
 		flags.ActionJPEGFixup: func() {
 			work(1, func(f *importer.File) (workCB, error) {
-				m, err := importer.GetMeta(f)
+				m, err := imp.Meta(f)
 				if err != nil {
 					return nil, err
 				}
@@ -549,7 +960,73 @@ func main() {
 			}
 			flag.Exit(imp.DoCleanup(list))
 		},
+		flags.ActionUndo: func() {
+			sessions, err := imp.Sessions()
+			flag.Exit(err)
+			args := flag.Args()
+			if len(args) == 0 {
+				for _, s := range sessions {
+					flag.Output(fmt.Sprintf(
+						"%d %s %s (%d changes)",
+						s.ID,
+						s.Time().Format(time.RFC3339),
+						s.Label,
+						len(s.Entries),
+					))
+				}
+				return
+			}
+
+			from, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				flag.Exit(fmt.Errorf("invalid session id '%s'", args[0]))
+			}
+
+			list := make([]journal.Session, 0)
+			for _, s := range sessions {
+				if s.ID >= from {
+					list = append(list, s)
+				}
+			}
+			if len(list) == 0 {
+				l.Println("nothing to undo")
+				return
+			}
+
+			for _, s := range list {
+				l.Printf("%d %s %s (%d changes)", s.ID, s.Time().Format(time.RFC3339), s.Label, len(s.Entries))
+			}
+			answer := "y"
+			if !flag.Yes() {
+				fmt.Printf("Undo %d sessions? [y/N]: ", len(list))
+				answer = ask()
+			}
+			if answer != "y" && answer != "Y" {
+				return
+			}
+
+			paths, err := imp.Undo(from)
+			for _, p := range paths {
+				flag.Output(p)
+			}
+			flag.Exit(err)
+		},
+		flags.ActionEmptyTrash: func() {
+			answer := "y"
+			if !flag.Yes() {
+				fmt.Print("Permanently delete all trashed files and clear the undo journal? [y/N]: ")
+				answer = ask()
+			}
+			if answer != "y" && answer != "Y" {
+				return
+			}
+			flag.Exit(imp.EmptyTrash())
+		},
 		flags.ActionInfo: func() {
+			if flag.Format() != "" {
+				structured(nil)
+				return
+			}
 			files := allMeta()
 
 			for _, f := range files {
@@ -699,7 +1176,7 @@ Exposure: %s
 			list := make([]gphotos.UploadTask, 0)
 			work(-1, func(f *importer.File) (workCB, error) {
 				return func() error {
-					m, err := importer.GetMeta(f)
+					m, err := imp.Meta(f)
 					if err != nil {
 						return err
 					}
@@ -753,7 +1230,7 @@ Exposure: %s
 			docs := gtimeline.New(glocationDir)
 			var first, last time.Time
 			work(-1, func(f *importer.File) (workCB, error) {
-				m, err := importer.GetMeta(f)
+				m, err := imp.Meta(f)
 				if err != nil {
 					return nil, err
 				}
@@ -838,6 +1315,82 @@ Exposure: %s
 
 			})
 		},
+		flags.ActionGallery: func() {
+			sizes := flag.Sizes()
+			if len(sizes) != 1 {
+				flag.Exit(errors.New("please specify the jpeg size to export with -sizes"))
+			}
+			dir := flag.GalleryDir()
+			if dir == "" {
+				flag.Exit(errors.New("please provide a gallery directory (-gallery)"))
+			}
+
+			g := gallery.New(dir, filepath.Base(dir))
+			var n int
+			albumPrefix := filepath.ToSlash(flag.AlbumDir()) + "/"
+			bySum := make(map[string]string)
+			for _, f := range allMeta() {
+				day := filepath.Dir(filepath.Dir(importer.NicePath("", f.f, *f.m)))
+				jpgs := make([]string, 0, len(f.m.Conv))
+				for jpg, conv := range f.m.Conv {
+					// album links are exported as album pages of the regular images.
+					if conv.Size == sizes[0] && !strings.HasPrefix(filepath.ToSlash(jpg), albumPrefix) {
+						jpgs = append(jpgs, jpg)
+					}
+				}
+				sort.Strings(jpgs)
+				for _, jpg := range jpgs {
+					// strip the size directory
+					rel := filepath.ToSlash(jpg)
+					p := path.Join(
+						path.Dir(path.Dir(rel)),
+						strings.TrimSuffix(path.Base(rel), path.Ext(rel)),
+					)
+					n++
+					if _, ok := bySum[f.m.Checksum]; !ok {
+						bySum[f.m.Checksum] = p
+					}
+					g.Add(gallery.Image{
+						Source:   filepath.Join(flag.JPEGDir(), jpg),
+						Path:     p,
+						Day:      filepath.ToSlash(day),
+						Title:    f.f.BaseFilename(),
+						Created:  f.d,
+						Rating:   f.m.Rating,
+						Tags:     f.m.Tags.Unique(),
+						Location: f.m.Location,
+					})
+				}
+			}
+
+			if n == 0 {
+				l.Printf("no converted jpegs of size %d to export", sizes[0])
+				return
+			}
+
+			albums, err := imp.Albums()
+			flag.Exit(err)
+			for _, a := range albums {
+				if flag.Album() != "" && a.Name != flag.Album() {
+					continue
+				}
+				paths := make([]string, 0, len(a.Items))
+				for _, s := range a.Items {
+					if p, ok := bySum[s]; ok {
+						paths = append(paths, p)
+					}
+				}
+				g.AddAlbum(a.Title, bySum[a.CoverItem()], paths)
+			}
+
+			l.Printf("exporting %d jpegs to %s", n, dir)
+			workers := runtime.NumCPU()
+			if workers > flag.MaxWorkers() {
+				workers = flag.MaxWorkers()
+			}
+			flag.Exit(g.Write(workers, progress))
+			progressDone()
+		},
 		flags.ActionVersion: func() {
 			fmt.Println(version.Get())
 		},
@@ -863,13 +1416,14 @@ Exposure: %s
 			return false
 		}
 
-		meta, err := importer.GetMeta(f)
+		meta, err := imp.Meta(f)
 		flag.Exit(err)
 		fcache[p] = mfil(meta, f)
 
 		return fcache[p]
 	}
 
+	journal.SetLabel(strings.Join(flag.Actions(), ","))
 	for _, action := range flag.Actions() {
 		cmds[action]()
 	}
@@ -42,6 +42,16 @@ func main() {
 	case flags.JPEGDir:
 		fallthrough
 	case flags.SourceDir:
+		fallthrough
+	case flags.GalleryDir:
+		fallthrough
+	case flags.PreviewCache:
+		fallthrough
+	case flags.Listen:
+		fallthrough
+	case flags.Query, flags.SaveQuery:
+		fallthrough
+	case flags.Album, flags.AlbumTitle, flags.AlbumDir, flags.PathTemplate:
 		return
 
 	case flags.Actions:
@@ -50,6 +60,9 @@ func main() {
 			opts = append(opts, i)
 		}
 
+	case flags.Format:
+		opts = append(opts, flags.FormatJSON, flags.FormatNDJSON, flags.FormatCSV)
+
 	case flags.GT:
 		for i := 0; i < 5; i++ {
 			opts = append(opts, strconv.Itoa(i))
@@ -59,7 +72,7 @@ func main() {
 			opts = append(opts, strconv.Itoa(i))
 		}
 
-	case flags.Checksum, flags.AlwaysYes, flags.Zero, flags.NoRawPrefix, flags.Verbose:
+	case flags.Checksum, flags.Verify, flags.EraseSource, flags.TetherWindow, flags.SimilarRate, flags.EmbeddedPreview, flags.EditedPreviews, flags.AlwaysYes, flags.Zero, flags.NoRawPrefix, flags.Verbose, flags.AlbumCover:
 		fl = ""
 
 	case flags.Undeleted:
@@ -84,6 +97,8 @@ func main() {
 		fl = ""
 	case flags.Video:
 		fl = ""
+	case flags.StackPicksOnly:
+		fl = ""
 	}
 
 	if fl == "" {
@@ -0,0 +1,508 @@
+package gallery
+
+import (
+	"fmt"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/frizinak/photos/meta"
+	"golang.org/x/image/draw"
+)
+
+const (
+	thumbDir  = "thumbs"
+	ThumbSize = 320
+)
+
+type Image struct {
+	// Source is the path of the (converted) jpeg.
+	Source string
+	// Path is the slash separated path (without extension) relative to the
+	// gallery root the image will be written to.
+	Path string
+	// Day is the slash separated directory of the day page.
+	Day string
+
+	Title    string
+	Created  time.Time
+	Rating   uint8
+	Tags     []string
+	Location *meta.Location
+}
+
+func (i *Image) File() string { return i.Path + ".jpg" }
+func (i *Image) ID() string   { return slug(path.Base(i.Path)) }
+
+func (i *Image) Thumb() string {
+	return path.Join(path.Dir(i.Path), thumbDir, path.Base(i.Path)+".jpg")
+}
+
+type day struct {
+	Path   string
+	Date   time.Time
+	Images []*Image
+}
+
+type year struct {
+	Year int
+	Days []*day
+}
+
+type tag struct {
+	Name   string
+	Path   string
+	Images []*Image
+}
+
+type album struct {
+	Title  string
+	Path   string
+	Cover  *Image
+	Images []*Image
+
+	cover string
+	paths []string
+}
+
+type point struct {
+	X, Y  float64
+	Image *Image
+	Day   *day
+}
+
+type place struct {
+	Name     string
+	Lat, Lng float64
+	Images   []*Image
+}
+
+type page struct {
+	Title  string
+	Root   string
+	Day    *day
+	Tag    *tag
+	Album  *album
+	Years  []*year
+	Tags   []*tag
+	Albums []*album
+
+	Width, Height int
+	Points        []point
+	Places        []*place
+}
+
+type Gallery struct {
+	dir    string
+	title  string
+	images []*Image
+	albums []*album
+}
+
+func New(dir, title string) *Gallery {
+	return &Gallery{dir: dir, title: title, images: make([]*Image, 0)}
+}
+
+func (g *Gallery) Add(img Image) { g.images = append(g.images, &img) }
+
+// AddAlbum adds an album page listing the images with the given Image.Path
+// in order. Unknown paths are ignored.
+func (g *Gallery) AddAlbum(title, cover string, paths []string) {
+	g.albums = append(g.albums, &album{Title: title, cover: cover, paths: paths})
+}
+
+func slug(s string) string {
+	b := strings.Builder{}
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_' {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
+
+func root(dir string) string {
+	if dir == "" || dir == "." {
+		return ""
+	}
+	return strings.Repeat("../", strings.Count(dir, "/")+1)
+}
+
+func (g *Gallery) Write(workers int, progress func(n, total int)) error {
+	sort.Slice(g.images, func(i, j int) bool {
+		a, b := g.images[i], g.images[j]
+		if a.Created.Equal(b.Created) {
+			return a.Path < b.Path
+		}
+		return a.Created.Before(b.Created)
+	})
+
+	if err := g.writeImages(workers, progress); err != nil {
+		return err
+	}
+
+	days := make(map[string]*day)
+	years := make(map[int]*year)
+	tags := make(map[string]*tag)
+	slugs := make(map[string]struct{})
+	yearList := make([]*year, 0)
+	tagList := make([]*tag, 0)
+	for _, img := range g.images {
+		d, ok := days[img.Day]
+		if !ok {
+			d = &day{Path: img.Day, Date: img.Created}
+			days[img.Day] = d
+			y, ok := years[img.Created.Year()]
+			if !ok {
+				y = &year{Year: img.Created.Year()}
+				years[y.Year] = y
+				yearList = append(yearList, y)
+			}
+			y.Days = append(y.Days, d)
+		}
+		d.Images = append(d.Images, img)
+
+		for _, t := range img.Tags {
+			tg, ok := tags[t]
+			if !ok {
+				s := slug(t)
+				if s == "" {
+					s = "tag"
+				}
+				base := s
+				for n := 1; ; n++ {
+					if _, ok := slugs[s]; !ok {
+						break
+					}
+					s = fmt.Sprintf("%s-%d", base, n)
+				}
+				slugs[s] = struct{}{}
+				tg = &tag{Name: t, Path: "tags/" + s + ".html"}
+				tags[t] = tg
+				tagList = append(tagList, tg)
+			}
+			tg.Images = append(tg.Images, img)
+		}
+	}
+
+	sort.Slice(tagList, func(i, j int) bool {
+		return strings.ToLower(tagList[i].Name) < strings.ToLower(tagList[j].Name)
+	})
+
+	albums := g.resolveAlbums()
+
+	if err := g.page("index.html", tmplIndex, page{Title: g.title, Years: yearList}); err != nil {
+		return err
+	}
+	for _, d := range days {
+		p := page{Title: d.Date.Format("Monday 02 January 2006"), Root: root(d.Path), Day: d}
+		if err := g.page(path.Join(d.Path, "index.html"), tmplDay, p); err != nil {
+			return err
+		}
+	}
+	if err := g.page("tags/index.html", tmplTags, page{Title: "Tags", Root: "../", Tags: tagList}); err != nil {
+		return err
+	}
+	for _, t := range tagList {
+		if err := g.page(t.Path, tmplTag, page{Title: t.Name, Root: "../", Tag: t}); err != nil {
+			return err
+		}
+	}
+	if err := g.page("albums/index.html", tmplAlbums, page{Title: "Albums", Root: "../", Albums: albums}); err != nil {
+		return err
+	}
+	for _, a := range albums {
+		if err := g.page(a.Path, tmplAlbum, page{Title: a.Title, Root: "../", Album: a}); err != nil {
+			return err
+		}
+	}
+
+	return g.page("map.html", tmplMap, g.mapPage(days))
+}
+
+func (g *Gallery) resolveAlbums() []*album {
+	byPath := make(map[string]*Image, len(g.images))
+	for _, img := range g.images {
+		byPath[img.Path] = img
+	}
+
+	slugs := make(map[string]struct{})
+	list := make([]*album, 0, len(g.albums))
+	for _, a := range g.albums {
+		a.Images = make([]*Image, 0, len(a.paths))
+		for _, p := range a.paths {
+			if img, ok := byPath[p]; ok {
+				a.Images = append(a.Images, img)
+			}
+		}
+		if len(a.Images) == 0 {
+			continue
+		}
+		a.Cover = a.Images[0]
+		if img, ok := byPath[a.cover]; ok {
+			a.Cover = img
+		}
+
+		s := slug(a.Title)
+		if s == "" {
+			s = "album"
+		}
+		base := s
+		for n := 1; ; n++ {
+			if _, ok := slugs[s]; !ok {
+				break
+			}
+			s = fmt.Sprintf("%s-%d", base, n)
+		}
+		slugs[s] = struct{}{}
+		a.Path = "albums/" + s + ".html"
+		list = append(list, a)
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Title) < strings.ToLower(list[j].Title)
+	})
+	return list
+}
+
+func (g *Gallery) mapPage(days map[string]*day) page {
+	p := page{Title: "Map", Width: 1000}
+	places := make(map[string]*place)
+	minLat, maxLat, minLng, maxLng := 90.0, -90.0, 180.0, -180.0
+	for _, img := range g.images {
+		l := img.Location
+		if l == nil {
+			continue
+		}
+		minLat, maxLat = min(minLat, l.Lat), max(maxLat, l.Lat)
+		minLng, maxLng = min(minLng, l.Lng), max(maxLng, l.Lng)
+
+		name := l.Name
+		if name == "" {
+			name = fmt.Sprintf("%.4f, %.4f", l.Lat, l.Lng)
+		}
+		pl, ok := places[name]
+		if !ok {
+			pl = &place{Name: name, Lat: l.Lat, Lng: l.Lng}
+			places[name] = pl
+			p.Places = append(p.Places, pl)
+		}
+		pl.Images = append(pl.Images, img)
+	}
+
+	if len(p.Places) == 0 {
+		return p
+	}
+
+	dlat, dlng := maxLat-minLat, maxLng-minLng
+	if dlat == 0 {
+		dlat = 1
+	}
+	if dlng == 0 {
+		dlng = 1
+	}
+	minLat, maxLat = minLat-dlat*0.05, maxLat+dlat*0.05
+	minLng, maxLng = minLng-dlng*0.05, maxLng+dlng*0.05
+	dlat, dlng = maxLat-minLat, maxLng-minLng
+	p.Height = int(float64(p.Width) * dlat / dlng)
+	if p.Height < 200 {
+		p.Height = 200
+	}
+	if p.Height > 2*p.Width {
+		p.Height = 2 * p.Width
+	}
+
+	for _, img := range g.images {
+		if l := img.Location; l != nil {
+			p.Points = append(p.Points, point{
+				X:     (l.Lng - minLng) / dlng * float64(p.Width),
+				Y:     (maxLat - l.Lat) / dlat * float64(p.Height),
+				Image: img,
+				Day:   days[img.Day],
+			})
+		}
+	}
+
+	sort.Slice(p.Places, func(i, j int) bool { return p.Places[i].Name < p.Places[j].Name })
+	return p
+}
+
+func min(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func (g *Gallery) page(rel string, t *template.Template, p page) error {
+	dest := filepath.Join(g.dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = t.Execute(f, p)
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, dest)
+}
+
+func (g *Gallery) writeImages(workers int, progress func(n, total int)) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	var sem sync.Mutex
+	var gerr error
+	var n int
+	work := make(chan *Image, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for img := range work {
+				err := g.writeImage(img)
+				sem.Lock()
+				if err != nil && gerr == nil {
+					gerr = err
+				}
+				n++
+				progress(n, len(g.images))
+				sem.Unlock()
+			}
+		}()
+	}
+
+	for _, img := range g.images {
+		work <- img
+	}
+	close(work)
+	wg.Wait()
+
+	return gerr
+}
+
+func upToDate(src os.FileInfo, dest string) bool {
+	st, err := os.Stat(dest)
+	return err == nil && !st.ModTime().Before(src.ModTime())
+}
+
+func (g *Gallery) writeImage(img *Image) error {
+	src, err := os.Stat(img.Source)
+	if err != nil {
+		return err
+	}
+
+	dest := filepath.Join(g.dir, filepath.FromSlash(img.File()))
+	thumb := filepath.Join(g.dir, filepath.FromSlash(img.Thumb()))
+	if !upToDate(src, dest) {
+		if err := copyFile(img.Source, dest); err != nil {
+			return err
+		}
+	}
+	if upToDate(src, thumb) {
+		return nil
+	}
+
+	return makeThumb(img.Source, thumb, ThumbSize)
+}
+
+func copyFile(src, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	tmp := dest + ".tmp"
+	d, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(d, s)
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, dest)
+}
+
+func makeThumb(src, dest string, size int) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	img, err := jpeg.Decode(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("could not decode '%s': %w", src, err)
+	}
+
+	b := img.Bounds()
+	w, h := size, size
+	if b.Dx() > b.Dy() {
+		h = b.Dy() * size / b.Dx()
+	} else {
+		w = b.Dx() * size / b.Dy()
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	tmp := dest + ".tmp"
+	o, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = jpeg.Encode(o, dst, &jpeg.Options{Quality: 80})
+	o.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, dest)
+}
@@ -0,0 +1,127 @@
+package gallery
+
+import (
+	"html/template"
+	"strings"
+)
+
+const tmplBase = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{ .Title }}</title>
+<style>
+body { margin: 0; font-family: sans-serif; background: #111; color: #ddd; }
+a { color: #9cf; text-decoration: none; }
+nav { padding: 1em; background: #000; }
+nav a { margin-right: 1em; }
+main { padding: 1em; }
+h1, h2 { font-weight: normal; }
+.grid { display: flex; flex-wrap: wrap; gap: 8px; }
+.grid figure { margin: 0; width: 320px; }
+.grid img { display: block; max-width: 320px; max-height: 320px; }
+figcaption { font-size: 0.8em; color: #999; padding: 4px 0; }
+ul.days { list-style: none; padding: 0; }
+svg { background: #1a1a1a; max-width: 100%; height: auto; }
+circle { fill: #f80; opacity: 0.7; }
+</style>
+</head>
+<body>
+<nav>
+<a href="{{ .Root }}index.html">Days</a>
+<a href="{{ .Root }}tags/index.html">Tags</a>
+<a href="{{ .Root }}albums/index.html">Albums</a>
+<a href="{{ .Root }}map.html">Map</a>
+</nav>
+<main>
+<h1>{{ .Title }}</h1>
+{{ template "content" . }}
+</main>
+</body>
+</html>
+{{ define "images" }}
+<div class="grid">
+{{- range .Images }}
+<figure id="{{ .ID }}">
+<a href="{{ $.Root }}{{ .File }}"><img loading="lazy" src="{{ $.Root }}{{ .Thumb }}" alt="{{ .Title }}"></a>
+<figcaption>
+{{ .Created.Format "2006-01-02 15:04" }} {{ stars .Rating }}
+{{- with .Location }}<br>{{ if .Name }}{{ .Name }}{{ else }}{{ printf "%.4f, %.4f" .Lat .Lng }}{{ end }}{{ end }}
+{{- if .Tags }}<br>{{ range $i, $t := .Tags }}{{ if $i }}, {{ end }}{{ $t }}{{ end }}{{ end }}
+</figcaption>
+</figure>
+{{- end }}
+</div>
+{{ end }}
+`
+
+var funcs = template.FuncMap{
+	"stars": func(r uint8) string { return strings.Repeat("★", int(r)) },
+	"images": func(root string, images []*Image) map[string]interface{} {
+		return map[string]interface{}{"Root": root, "Images": images}
+	},
+}
+
+func tmpl(content string) *template.Template {
+	return template.Must(template.Must(
+		template.New("base").Funcs(funcs).Parse(tmplBase),
+	).New("content").Parse(content))
+}
+
+var tmplIndex = tmpl(`
+{{- range .Years }}
+<h2>{{ .Year }}</h2>
+<ul class="days">
+{{- range .Days }}
+<li><a href="{{ .Path }}/index.html">{{ .Date.Format "Mon 02 January" }}</a> ({{ len .Images }})</li>
+{{- end }}
+</ul>
+{{- end }}
+`)
+
+var tmplDay = tmpl(`{{ template "images" images .Root .Day.Images }}`)
+
+var tmplTags = tmpl(`
+<ul>
+{{- range .Tags }}
+<li><a href="{{ $.Root }}{{ .Path }}">{{ .Name }}</a> ({{ len .Images }})</li>
+{{- end }}
+</ul>
+`)
+
+var tmplTag = tmpl(`{{ template "images" images .Root .Tag.Images }}`)
+
+var tmplAlbums = tmpl(`
+{{- if .Albums }}
+<div class="grid">
+{{- range .Albums }}
+<figure>
+<a href="{{ $.Root }}{{ .Path }}"><img loading="lazy" src="{{ $.Root }}{{ .Cover.Thumb }}" alt="{{ .Title }}"></a>
+<figcaption>{{ .Title }} ({{ len .Images }})</figcaption>
+</figure>
+{{- end }}
+</div>
+{{- else }}
+<p>No albums.</p>
+{{- end }}
+`)
+
+var tmplAlbum = tmpl(`{{ template "images" images .Root .Album.Images }}`)
+
+var tmplMap = tmpl(`
+{{- if .Points }}
+<svg viewBox="0 0 {{ .Width }} {{ .Height }}" width="{{ .Width }}" height="{{ .Height }}">
+{{- range .Points }}
+<a href="{{ .Day.Path }}/index.html#{{ .Image.ID }}"><circle cx="{{ printf "%.1f" .X }}" cy="{{ printf "%.1f" .Y }}" r="6"><title>{{ .Image.Title }}</title></circle></a>
+{{- end }}
+</svg>
+<ul>
+{{- range .Places }}
+<li><a href="https://www.openstreetmap.org/?mlat={{ .Lat }}&amp;mlon={{ .Lng }}#map=15/{{ .Lat }}/{{ .Lng }}">{{ .Name }}</a> ({{ len .Images }})</li>
+{{- end }}
+</ul>
+{{- else }}
+<p>No images with location information.</p>
+{{- end }}
+`)
@@ -0,0 +1,241 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/frizinak/photos/album"
+	"github.com/frizinak/photos/journal"
+)
+
+const (
+	albumsDir       = ".albums"
+	DefaultAlbumDir = "Albums"
+)
+
+// SetAlbumDir sets the directory, relative to the collection directory,
+// albums are materialized in.
+func (i *Importer) SetAlbumDir(dir string) { i.albumDir = dir }
+
+func (i *Importer) albumPath(name string) string {
+	return album.Path(filepath.Join(i.rawDir, albumsDir), name)
+}
+
+func (i *Importer) Albums() ([]album.Album, error) {
+	return album.List(filepath.Join(i.rawDir, albumsDir))
+}
+
+// Album loads the album with the given name, an error satisfying
+// os.IsNotExist is returned if it does not exist.
+func (i *Importer) Album(name string) (album.Album, error) {
+	if err := album.ValidName(name); err != nil {
+		return album.Album{}, err
+	}
+	return album.Load(i.albumPath(name))
+}
+
+func (i *Importer) SaveAlbum(a album.Album) error {
+	if err := album.ValidName(a.Name); err != nil {
+		return err
+	}
+	p := i.albumPath(a.Name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	if err := journal.WriteIn(i.rawDir, p); err != nil {
+		return err
+	}
+	return a.Save(p)
+}
+
+// AlbumLinkDir is the directory the album is materialized in.
+func (i *Importer) AlbumLinkDir(a album.Album) string {
+	title := strings.TrimSpace(strings.ReplaceAll(a.Title, string(filepath.Separator), "-"))
+	if title == "" || title[0] == '.' {
+		title = a.Name
+	}
+	return filepath.Join(i.colDir, i.albumDir, title)
+}
+
+func (i *Importer) isAlbumLink(link string) bool {
+	rel, err := filepath.Rel(filepath.Join(i.colDir, i.albumDir), link)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Checksums maps the checksum of each raw to its File.
+func (i *Importer) Checksums() (map[string]*File, error) {
+	s, err := i.loadSums()
+	if err != nil {
+		return nil, err
+	}
+	return s.m, nil
+}
+
+// sidecarPaths returns the paths of all sidecar files a link could have.
+func (i *Importer) sidecarPaths(link string) []string {
+	l := []string{i.pp3Path(link), i.xmpPath(link)}
+	if pho, err := i.phoPath(link); err == nil {
+		l = append(l, pho)
+	}
+	return l
+}
+
+// moveLink renames a symlink along with its sidecar files.
+func (i *Importer) moveLink(src, dst string) error {
+	if src == dst {
+		return nil
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		return fmt.Errorf("refusing to overwrite '%s'", dst)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	real := target
+	if !filepath.IsAbs(real) {
+		real = filepath.Join(filepath.Dir(src), target)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	dir, err := Abs(filepath.Dir(dst))
+	if err != nil {
+		return err
+	}
+	if real, err = Abs(real); err != nil {
+		return err
+	}
+	if target, err = filepath.Rel(dir, real); err != nil {
+		return err
+	}
+
+	i.verbose.Printf("moving '%s' to '%s'", src, dst)
+	if err := os.Symlink(target, dst); err != nil {
+		return err
+	}
+	if err := os.Remove(src); err != nil {
+		return err
+	}
+
+	srcSide, dstSide := i.sidecarPaths(src), i.sidecarPaths(dst)
+	for n := range srcSide {
+		if n >= len(dstSide) {
+			break
+		}
+		if err := os.Rename(srcSide[n], dstSide[n]); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MaterializeAlbum syncs the album's link directory with its items. Links are
+// prefixed with their position so any file browser (and the converted jpegs)
+// respect the album order, sidecars are moved along when the order changes.
+func (i *Importer) MaterializeAlbum(a album.Album, sums map[string]*File) error {
+	dir := i.AlbumLinkDir(a)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	dir, err := Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]string, len(entries))
+	for _, e := range entries {
+		if e.Type()&os.ModeSymlink == 0 {
+			continue
+		}
+		p := filepath.Join(dir, e.Name())
+		real, err := Abs(p)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+			real = ""
+		}
+		if _, ok := existing[real]; ok || real == "" {
+			if err := i.remove(p); err != nil {
+				return err
+			}
+			continue
+		}
+		existing[real] = p
+	}
+
+	want := make(map[string]string, len(a.Items))
+	for n, s := range a.Items {
+		f, ok := sums[s]
+		if !ok {
+			i.log.Printf("album %s: no file with checksum %s", a.Name, s)
+			continue
+		}
+		m, err := GetMeta(f)
+		if err != nil {
+			return err
+		}
+		if m.Deleted {
+			continue
+		}
+		real, err := Abs(f.Path())
+		if err != nil {
+			return err
+		}
+		want[real] = filepath.Join(dir, fmt.Sprintf("%04d--%s", n+1, f.BaseFilename()))
+	}
+
+	for real, p := range existing {
+		if _, ok := want[real]; !ok {
+			if err := i.remove(p); err != nil {
+				return err
+			}
+			delete(existing, real)
+		}
+	}
+
+	// move out of the way first so reordering can't collide.
+	for real, p := range existing {
+		if p == want[real] {
+			continue
+		}
+		tmp := filepath.Join(dir, ".tmp-"+filepath.Base(p))
+		if err := i.moveLink(p, tmp); err != nil {
+			return err
+		}
+		existing[real] = tmp
+	}
+
+	for real, dst := range want {
+		if p, ok := existing[real]; ok {
+			if err := i.moveLink(p, dst); err != nil {
+				return err
+			}
+			continue
+		}
+
+		link, err := filepath.Rel(dir, real)
+		if err != nil {
+			return fmt.Errorf("Refuse to make non-relative symlinks, make sure both your raw directory and collection directory are on the same filesystem: %w", err)
+		}
+		i.verbose.Printf("linking '%s' to '%s'", link, dst)
+		if err := os.Symlink(link, dst); err != nil {
+			return err
+		}
+	}
+
+	i.ClearCache()
+	return nil
+}
@@ -50,7 +50,7 @@ func rmEmpty(dir string) (bool, error) {
 
 func (i *Importer) DoCleanup(paths []string) error {
 	for _, f := range paths {
-		if err := os.Remove(f); err != nil {
+		if err := i.remove(f); err != nil {
 			return err
 		}
 	}
@@ -74,7 +74,7 @@ func (i *Importer) Cleanup(minRating int) ([]string, error) {
 	}
 
 	converted := make(map[string]struct{}, len(all))
-	pp3 := make(map[string]struct{}, len(all))
+	sidecars := make(map[string]struct{}, len(all))
 
 	for _, f := range all {
 		m, err := GetMeta(f)
@@ -103,11 +103,13 @@ func (i *Importer) Cleanup(minRating int) ([]string, error) {
 			return nil, err
 		}
 		for _, l := range links {
-			rel, err := filepath.Rel(i.colDir, i.pp3Path(l))
-			if err != nil {
-				return nil, err
+			for _, p := range []string{i.pp3Path(l), i.xmpPath(l)} {
+				rel, err := filepath.Rel(i.colDir, p)
+				if err != nil {
+					return nil, err
+				}
+				sidecars[rel] = struct{}{}
 			}
-			pp3[rel] = struct{}{}
 		}
 	}
 
@@ -130,7 +132,10 @@ func (i *Importer) Cleanup(minRating int) ([]string, error) {
 	}
 
 	_, err = i.scanDir(i.colDir, func(path string) (bool, error) {
-		if strings.ToLower(filepath.Ext(path)) != ".pp3" {
+		ext := strings.ToLower(filepath.Ext(path))
+		switch ext {
+		case ".pp3", ".xmp":
+		default:
 			return true, nil
 		}
 
@@ -139,9 +144,16 @@ func (i *Importer) Cleanup(minRating int) ([]string, error) {
 			return false, err
 		}
 
-		if _, ok := pp3[rel]; !ok {
-			delete = append(delete, path)
+		if _, ok := sidecars[rel]; ok {
+			return true, nil
+		}
+		// keep darktable duplicates of sidecars that are kept.
+		if orig, ok := xmpVersionOf(rel); ok && ext == ".xmp" {
+			if _, ok := sidecars[orig]; ok {
+				return true, nil
+			}
 		}
+		delete = append(delete, path)
 
 		return true, nil
 	})
@@ -35,6 +35,22 @@ type info struct {
 	created         time.Time
 	createdOverride bool
 	lat, lng        *float64
+	meta            *meta.Meta
+}
+
+func metaInfo(m meta.Meta) info {
+	var lat, lng *float64
+	if m.Location != nil {
+		lat, lng = &m.Location.Lat, &m.Location.Lng
+	}
+
+	return info{
+		created:         m.CreatedTime(),
+		createdOverride: m.CreatedOverride,
+		lat:             lat,
+		lng:             lng,
+		meta:            &m,
+	}
 }
 
 func (i *Importer) convertPP3(input, output string, pp PP3, size int, info info) error {
@@ -73,6 +89,9 @@ func (i *Importer) convertPP3(input, output string, pp PP3, size int, info info)
 	err = i.jpegRewrite(tmp, func(e *exif.Exif) (bool, error) {
 		return i.Exif(e, info)
 	})
+	if err == nil && info.meta != nil {
+		err = i.jpegMeta(tmp, *info.meta)
+	}
 
 	if err != nil {
 		os.Remove(tmp)
@@ -109,8 +128,14 @@ func (i *Importer) convertPho(input, output string, pho Pho, size int, info info
 		Add(element.SaveFile(output, ".jpg", 92))
 
 	rctx := pipeline.NewContext(conf.Verbose, i.log.Writer(), pipeline.ModeConvert, context.Background())
-	_, err = line.Do(rctx, nil)
-	return err
+	if _, err = line.Do(rctx, nil); err != nil {
+		return err
+	}
+
+	if info.meta != nil {
+		return i.jpegMeta(output, *info.meta)
+	}
+	return nil
 }
 
 func (i *Importer) Exif(e *exif.Exif, info info) (bool, error) {
@@ -322,22 +347,43 @@ func (i *Importer) JPEGTZ(file string, t time.Time) error {
 	})
 }
 
+// embedHash hashes the parts of m that are embedded in converted jpegs.
+func embedHash(m meta.Meta) string {
+	h := crc64.New(crc64.MakeTable(crc64.ISO))
+	fmt.Fprintf(h, "%d\n%t\n%d\n", m.Rating, m.Deleted, m.Created)
+	for _, t := range m.Tags.Unique() {
+		fmt.Fprintf(h, "%s\n", t)
+	}
+	if l := m.Location; l != nil {
+		fmt.Fprintf(h, "%f\n%f\n%s\n%s\n", l.Lat, l.Lng, l.Name, l.Address)
+	}
+	// only when set, to not invalidate conversions from before labels.
+	if m.Label != "" {
+		fmt.Fprintf(h, "label:%s\n", m.Label)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sidecarHash hashes a sidecar and the size it is converted at.
+func sidecarHash(sc sidecar, size int) string {
+	h := crc64.New(crc64.MakeTable(crc64.ISO))
+	fmt.Fprintf(h, "%d\n", size)
+	sc.Hash(h)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 func (i *Importer) convertIfUpdated(
-	loc *meta.Location,
+	m meta.Meta,
 	link,
 	dir,
 	output string,
 	sidecar sidecar,
 	converted map[string]meta.Converted,
 	size int,
-	created time.Time,
-	createdOverride bool,
 	checkOnly bool,
 ) (bool, string, error) {
-	h := crc64.New(crc64.MakeTable(crc64.ISO))
-	fmt.Fprintf(h, "%d\n", size)
-	sidecar.Hash(h)
-	hash := hex.EncodeToString(h.Sum(nil))
+	convHash := sidecarHash(sidecar, size)
+	hash := convHash + ":" + embedHash(m)
 
 	output = fmt.Sprintf("%s.jpg", output)
 	rel, err := filepath.Rel(dir, output)
@@ -353,27 +399,31 @@ func (i *Importer) convertIfUpdated(
 		return false, rel, err
 	}
 
-	if h, ok := converted[rel]; exists && ok && h.Hash == hash {
+	prev, ok := converted[rel]
+	if exists && ok && prev.Hash == hash {
 		return false, rel, nil
 	}
 	converted[rel] = meta.Converted{Hash: hash, Size: size}
 
+	// only the embedded meta changed, no need to convert again.
+	reembed := exists && ok && strings.SplitN(prev.Hash, ":", 2)[0] == convHash
 	if checkOnly {
 		return true, rel, nil
 	}
-	os.MkdirAll(filepath.Dir(output), 0755)
-	var lat, lng *float64
-	if loc != nil {
-		lat, lng = &loc.Lat, &loc.Lng
-	}
 
-	info := info{
-		created:         created,
-		createdOverride: createdOverride,
-		lat:             lat,
-		lng:             lng,
+	info := metaInfo(m)
+
+	if reembed {
+		// the creation date and location live in exif.
+		err := i.jpegRewrite(output, func(e *exif.Exif) (bool, error) { return i.Exif(e, info) })
+		if err != nil {
+			return true, rel, err
+		}
+		return true, rel, i.jpegMeta(output, m)
 	}
 
+	os.MkdirAll(filepath.Dir(output), 0755)
+
 	switch sc := sidecar.(type) {
 	case PP3:
 		return true, rel, i.convertPP3(link, output, sc, size, info)
@@ -387,6 +437,10 @@ func (i *Importer) Unedited(f *File) (bool, error) {
 	pp3edited := true
 	phoedited := true
 	err := i.walkLinks(f, func(link string) (bool, error) {
+		// album links use the edits of the regular links when converting.
+		if i.isAlbumLink(link) {
+			return true, nil
+		}
 		pho, err := i.GetPho(link)
 		if err != nil && errors.Is(err, os.ErrNotExist) {
 			err = nil
@@ -430,6 +484,7 @@ func (i *Importer) Convert(f *File, sizes []int) error {
 func (i *Importer) fileConvert(f *File, sizes []int, checkOnly bool) (bool, error) {
 	links := []string{}
 	sidecars := []sidecar{}
+	albumLinks := []string{}
 	err := i.walkLinks(f, func(link string) (bool, error) {
 		pho, err := i.GetPho(link)
 		if err != nil && !errors.Is(err, os.ErrNotExist) {
@@ -445,6 +500,10 @@ func (i *Importer) fileConvert(f *File, sizes []int, checkOnly bool) (bool, erro
 		if err != nil && !errors.Is(err, os.ErrNotExist) {
 			return false, err
 		}
+		if i.isAlbumLink(link) && !pp3.Edited() {
+			albumLinks = append(albumLinks, link)
+			return true, nil
+		}
 		if err == nil {
 			sidecars = append(sidecars, pp3)
 			links = append(links, link)
@@ -456,6 +515,24 @@ func (i *Importer) fileConvert(f *File, sizes []int, checkOnly bool) (bool, erro
 		return false, err
 	}
 
+	// album links that were not edited themselves are converted with the
+	// sidecar of the first regular link.
+	if len(albumLinks) != 0 {
+		var sc sidecar
+		for n, l := range links {
+			if !i.isAlbumLink(l) {
+				sc = sidecars[n]
+				break
+			}
+		}
+		if sc != nil {
+			for _, l := range albumLinks {
+				links = append(links, l)
+				sidecars = append(sidecars, sc)
+			}
+		}
+	}
+
 	if len(links) == 0 {
 		return false, nil
 	}
@@ -484,15 +561,13 @@ func (i *Importer) fileConvert(f *File, sizes []int, checkOnly bool) (bool, erro
 			fn = fn[0 : len(fn)-len(ext)]
 			output := filepath.Join(dir, strconv.Itoa(s), fn)
 			conv, rel, err := i.convertIfUpdated(
-				m.Location,
+				m,
 				links[n],
 				i.convDir,
 				output,
 				sidecars[n],
 				conv,
 				s,
-				m.CreatedTime(),
-				m.CreatedOverride,
 				checkOnly,
 			)
 			changed = changed || conv
@@ -0,0 +1,190 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/frizinak/photos/meta"
+	"github.com/frizinak/photos/xmp"
+)
+
+var (
+	jpegXMPHeader       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	jpegPhotoshopHeader = []byte("Photoshop 3.0\x00")
+)
+
+const (
+	jpegSOI   = 0xd8
+	jpegSOS   = 0xda
+	jpegAPP0  = 0xe0
+	jpegAPP1  = 0xe1
+	jpegAPP13 = 0xed
+)
+
+type jpegSegment struct {
+	marker byte
+	data   []byte
+}
+
+func (s jpegSegment) is(marker byte, header []byte) bool {
+	return s.marker == marker && bytes.HasPrefix(s.data, header)
+}
+
+func (s jpegSegment) write(w io.Writer) error {
+	if len(s.data)+2 > 0xffff {
+		return fmt.Errorf("jpeg segment too large: %d bytes", len(s.data))
+	}
+	hdr := []byte{0xff, s.marker, 0, 0}
+	binary.BigEndian.PutUint16(hdr[2:], uint16(len(s.data)+2))
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	_, err := w.Write(s.data)
+	return err
+}
+
+// iptcKeywords creates a photoshop image resource block containing an IPTC
+// record with the given keywords.
+func iptcKeywords(keywords []string) []byte {
+	iptc := bytes.NewBuffer(nil)
+	dataset := func(record, tag byte, value []byte) {
+		iptc.Write([]byte{0x1c, record, tag})
+		binary.Write(iptc, binary.BigEndian, uint16(len(value)))
+		iptc.Write(value)
+	}
+
+	dataset(1, 90, []byte("\x1b%G"))
+	dataset(2, 0, []byte{0, 4})
+	for _, kw := range keywords {
+		v := []byte(kw)
+		if len(v) > 64 {
+			v = v[:64]
+		}
+		dataset(2, 25, v)
+	}
+
+	if iptc.Len()%2 != 0 {
+		iptc.WriteByte(0)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	buf.Write(jpegPhotoshopHeader)
+	buf.WriteString("8BIM")
+	binary.Write(buf, binary.BigEndian, uint16(0x0404))
+	buf.Write([]byte{0, 0})
+	binary.Write(buf, binary.BigEndian, uint32(iptc.Len()))
+	buf.Write(iptc.Bytes())
+	return buf.Bytes()
+}
+
+// jpegMeta replaces the xmp packet and iptc data in jpeg r with the given
+// meta and writes the result to w.
+func jpegMeta(r io.Reader, w io.Writer, m meta.Meta) error {
+	x := xmp.New()
+	metaToXMP(x, m)
+	packet := bytes.NewBuffer(nil)
+	packet.Write(jpegXMPHeader)
+	if _, err := x.WriteTo(packet); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(br, soi); err != nil {
+		return err
+	}
+	if soi[0] != 0xff || soi[1] != jpegSOI {
+		return errors.New("not a jpeg")
+	}
+	bw.Write(soi)
+
+	inserted := false
+	insert := func() error {
+		inserted = true
+		if err := (jpegSegment{jpegAPP1, packet.Bytes()}).write(bw); err != nil {
+			return err
+		}
+		if len(m.Tags) == 0 {
+			return nil
+		}
+		return jpegSegment{jpegAPP13, iptcKeywords(m.Tags.Unique())}.write(bw)
+	}
+
+	for {
+		hdr := make([]byte, 4)
+		if _, err := io.ReadFull(br, hdr[:2]); err != nil {
+			return err
+		}
+		if hdr[0] != 0xff {
+			return errors.New("invalid jpeg marker")
+		}
+		marker := hdr[1]
+		if marker == jpegSOS {
+			if !inserted {
+				if err := insert(); err != nil {
+					return err
+				}
+			}
+			bw.Write(hdr[:2])
+			if _, err := io.Copy(bw, br); err != nil {
+				return err
+			}
+			return bw.Flush()
+		}
+
+		if _, err := io.ReadFull(br, hdr[2:]); err != nil {
+			return err
+		}
+		n := int(binary.BigEndian.Uint16(hdr[2:]))
+		if n < 2 {
+			return errors.New("invalid jpeg segment length")
+		}
+		seg := jpegSegment{marker: marker, data: make([]byte, n-2)}
+		if _, err := io.ReadFull(br, seg.data); err != nil {
+			return err
+		}
+
+		if seg.is(jpegAPP1, jpegXMPHeader) || seg.is(jpegAPP13, jpegPhotoshopHeader) {
+			continue
+		}
+
+		if !inserted && marker != jpegAPP0 && marker != jpegAPP1 {
+			if err := insert(); err != nil {
+				return err
+			}
+		}
+
+		if err := seg.write(bw); err != nil {
+			return err
+		}
+	}
+}
+
+func (i *Importer) jpegMeta(file string, m meta.Meta) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tmp := file + ".tmp"
+	w, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = jpegMeta(f, w, m)
+	w.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
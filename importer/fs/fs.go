@@ -16,6 +16,7 @@ type FS struct {
 	dir       string
 	recursive bool
 	exts      map[string]struct{}
+	files     []*importer.File
 }
 
 func New(dir string, recursive bool, exts []string) *FS {
@@ -24,10 +25,19 @@ func New(dir string, recursive bool, exts []string) *FS {
 		e = strings.ToLower(e)
 		m[e] = struct{}{}
 	}
-	return &FS{dir, recursive, m}
+	return &FS{dir: dir, recursive: recursive, exts: m}
+}
+
+// NewFiles creates a backend that imports the given files instead of
+// scanning a directory.
+func NewFiles(files []*importer.File) *FS {
+	return &FS{files: files}
 }
 
 func (f *FS) Available() (bool, error) {
+	if f.files != nil {
+		return true, nil
+	}
 	stat, err := os.Stat(f.dir)
 	if err == nil && !stat.IsDir() {
 		return true, fmt.Errorf("'%s' is not a directory", f.dir)
@@ -97,24 +107,42 @@ func (f *FS) Import(log *log.Logger, destination string, imp *importer.Import) e
 				fn := filepath.Base(f.BasePath())
 				d := importer.NewFile(destination, f.Bytes(), fn)
 				p := d.Path()
-				if err := copy(f.BasePath(), p); err != nil {
+				cs, err := copy(f.BasePath(), p, imp.Verify())
+				if err != nil {
 					errs <- fmt.Errorf("%s could not be copied to %s: %w", f.BasePath(), p, err)
 					break
 				}
 
-				if err := imp.Add(p, d); err != nil {
+				if err := imp.AddVerified(p, d, cs); err != nil {
 					errs <- err
 					break
 				}
+
+				if imp.Erase() {
+					ok, err := imp.Imported(cs)
+					if err != nil {
+						errs <- err
+						break
+					}
+					if ok {
+						log.Printf("erasing %s", f.BasePath())
+						if err := os.Remove(f.BasePath()); err != nil {
+							errs <- err
+							break
+						}
+					}
+				}
 			}
 			wg.Done()
 		}()
 	}
 
-	d := []*importer.File{}
-	err := f.scan(f.dir, &d)
-	if err != nil {
-		return err
+	d := f.files
+	if d == nil {
+		d = []*importer.File{}
+		if err := f.scan(f.dir, &d); err != nil {
+			return err
+		}
 	}
 
 	n := 0
@@ -146,18 +174,26 @@ outer:
 	}
 }
 
-func copy(src, dst string) error {
+// copy copies src to dst, returning the checksum of src if sum is true.
+func copy(src, dst string, sum bool) (string, error) {
 	s, err := os.Open(src)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer s.Close()
 	d, err := os.Create(dst)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer d.Close()
 
-	_, err = io.Copy(d, s)
-	return err
+	var cs string
+	if sum {
+		_, cs, err = importer.CopySum(d, s, nil)
+	} else {
+		_, err = io.Copy(d, s)
+	}
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return cs, err
 }
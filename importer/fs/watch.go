@@ -0,0 +1,182 @@
+package fs
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/frizinak/photos/importer"
+)
+
+// Watcher reports files in a set of directories once they are done being
+// written to, i.e.: their size and modification time did not change for
+// a while. Changes are detected with inotify where available and by
+// periodically scanning the directories elsewhere.
+type Watcher struct {
+	dirs      []string
+	recursive bool
+	exts      map[string]struct{}
+	settle    time.Duration
+}
+
+func NewWatcher(dirs []string, recursive bool, exts []string, settle time.Duration) *Watcher {
+	m := map[string]struct{}{}
+	for _, e := range exts {
+		m[strings.ToLower(e)] = struct{}{}
+	}
+	if settle <= 0 {
+		settle = time.Second
+	}
+	return &Watcher{dirs: dirs, recursive: recursive, exts: m, settle: settle}
+}
+
+type watchState struct {
+	size  int64
+	mod   time.Time
+	since time.Time
+}
+
+func (s watchState) same(o os.FileInfo) bool {
+	return s.size == o.Size() && s.mod.Equal(o.ModTime())
+}
+
+// Watch blocks until stop is closed and calls cb with each batch of files
+// that settled. Files that are already present are reported as well.
+func (w *Watcher) Watch(log *log.Logger, stop <-chan struct{}, cb func([]*importer.File) error) error {
+	for _, d := range w.dirs {
+		if s, err := os.Stat(d); err != nil || !s.IsDir() {
+			if err == nil {
+				err = &os.PathError{Op: "watch", Path: d, Err: os.ErrInvalid}
+			}
+			return err
+		}
+	}
+
+	changes := make(chan string, 512)
+	errs := make(chan error, 1)
+	closer, err := w.notify(log, changes, errs)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	pending := make(map[string]watchState)
+	settled := make(map[string]watchState)
+
+	var touch func(path string, now time.Time)
+	touch = func(path string, now time.Time) {
+		s, err := os.Stat(path)
+		if err != nil {
+			delete(pending, path)
+			delete(settled, path)
+			return
+		}
+		if s.IsDir() {
+			if !w.recursive && !w.root(path) {
+				return
+			}
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				log.Printf("[WARN] could not read '%s': %s", path, err)
+				return
+			}
+			for _, e := range entries {
+				if e.IsDir() && !w.recursive {
+					continue
+				}
+				touch(filepath.Join(path, e.Name()), now)
+			}
+			return
+		}
+		if !s.Mode().IsRegular() || !w.supported(path) {
+			return
+		}
+		if st, ok := settled[path]; ok && st.same(s) {
+			return
+		}
+		delete(settled, path)
+		if st, ok := pending[path]; ok && st.same(s) {
+			return
+		}
+		pending[path] = watchState{size: s.Size(), mod: s.ModTime(), since: now}
+	}
+
+	now := time.Now()
+	for _, d := range w.dirs {
+		touch(d, now)
+	}
+
+	interval := w.settle / 4
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case err := <-errs:
+			return err
+		case p := <-changes:
+			touch(p, time.Now())
+		case now := <-tick.C:
+			batch := make([]*importer.File, 0)
+			for p, st := range pending {
+				// catches writes inotify did not report, e.g.: on network
+				// mounts.
+				touch(p, now)
+				if n, ok := pending[p]; !ok || n != st || now.Sub(st.since) < w.settle {
+					continue
+				}
+				delete(pending, p)
+				settled[p] = st
+				batch = append(batch, importer.NewFile(filepath.Dir(p), st.size, filepath.Base(p)))
+			}
+			if len(batch) == 0 {
+				continue
+			}
+			sort.Slice(batch, func(i, j int) bool { return batch[i].BasePath() < batch[j].BasePath() })
+			if err := cb(batch); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (w *Watcher) root(path string) bool {
+	for _, d := range w.dirs {
+		if filepath.Clean(d) == filepath.Clean(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Watcher) supported(path string) bool {
+	_, ok := w.exts[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// walk calls cb for root and, if recursive, each directory below it.
+func (w *Watcher) walk(root string, cb func(dir string) error) error {
+	if !w.recursive {
+		return cb(root)
+	}
+	return filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		return cb(p)
+	})
+}
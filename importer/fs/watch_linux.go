@@ -0,0 +1,119 @@
+//go:build linux
+
+package fs
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE |
+	syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF |
+	syscall.IN_MOVE_SELF
+
+// notify sends the paths of created, written and moved files and
+// directories to changes.
+func (w *Watcher) notify(log *log.Logger, changes chan string, errs chan<- error) (func(), error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// non-blocking so reads go through the runtime poller and Close
+	// unblocks them.
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	watches := make(map[int32]string)
+	add := func(root string) error {
+		return w.walk(root, func(dir string) error {
+			wd, err := syscall.InotifyAddWatch(fd, dir, inotifyMask)
+			if err != nil {
+				return os.NewSyscallError("inotify_add_watch "+dir, err)
+			}
+			watches[int32(wd)] = dir
+			return nil
+		})
+	}
+
+	for _, d := range w.dirs {
+		if err := add(d); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				if !errors.Is(err, os.ErrClosed) {
+					errs <- err
+				}
+				return
+			}
+
+			for o := 0; o+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[o]))
+				name := buf[o+syscall.SizeofInotifyEvent : o+syscall.SizeofInotifyEvent+int(ev.Len)]
+				o += syscall.SizeofInotifyEvent + int(ev.Len)
+
+				if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+					log.Println("[WARN] inotify queue overflowed, rescanning")
+					for _, d := range w.dirs {
+						changes <- d
+					}
+					continue
+				}
+
+				dir, ok := watches[ev.Wd]
+				if !ok {
+					continue
+				}
+				if ev.Mask&syscall.IN_IGNORED != 0 {
+					delete(watches, ev.Wd)
+					continue
+				}
+				if ev.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0 {
+					if w.root(dir) {
+						errs <- errors.New("watched directory '" + dir + "' was removed")
+						return
+					}
+					continue
+				}
+
+				p := filepath.Join(dir, string(bytes.TrimRight(name, "\x00")))
+				if ev.Mask&syscall.IN_ISDIR != 0 {
+					if !w.recursive {
+						continue
+					}
+					if err := add(p); err != nil {
+						log.Printf("[WARN] not watching '%s': %s", p, err)
+						continue
+					}
+				}
+				changes <- p
+			}
+		}
+	}()
+
+	return func() {
+		f.Close()
+		// drain so the reader is never stuck on a send.
+		for {
+			select {
+			case <-changes:
+			case <-done:
+				return
+			}
+		}
+	}, nil
+}
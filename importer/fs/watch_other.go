@@ -0,0 +1,39 @@
+//go:build !linux
+
+package fs
+
+import (
+	"log"
+	"time"
+)
+
+// notify periodically sends the watched directories to changes as inotify
+// is not available.
+func (w *Watcher) notify(log *log.Logger, changes chan string, errs chan<- error) (func(), error) {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tick := time.NewTicker(w.settle)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+			}
+			for _, d := range w.dirs {
+				select {
+				case changes <- d:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}, nil
+}
@@ -4,7 +4,9 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
@@ -22,6 +24,7 @@ var (
 	filenameRE     = regexp.MustCompile(`^#(\d+)\s+([^\.]+\.[^\s]+)`)
 	fullInfoPathRE = regexp.MustCompile(`Information on file '(.*?)'.*?folder '(.*?)'`)
 	sizeRE         = regexp.MustCompile(`Size:\s+(\d+) byte`)
+	savingRE       = regexp.MustCompile(`^Saving file as (.+)$`)
 )
 
 type GPhoto2 struct {
@@ -217,11 +220,134 @@ func (g *GPhoto2) Import(log *log.Logger, destination string, imp *importer.Impo
 		return err
 	}
 
-	for _, f := range files {
-		if err := imp.Add(f.BasePath(), f); err != nil {
+	// the cli can't checksum while downloading, read each file again.
+	sums := make([]string, len(indices))
+	if imp.Verify() || imp.Erase() {
+		for n, ix := range indices {
+			if sums[n], err = g.sum(ix); err != nil {
+				return err
+			}
+		}
+	}
+
+	for n, f := range files {
+		if err := imp.AddVerified(f.BasePath(), f, sums[n]); err != nil {
+			return err
+		}
+	}
+
+	if !imp.Erase() {
+		return nil
+	}
+
+	erase := make([]string, 0, len(indices))
+	for n, ix := range indices {
+		ok, err := imp.Imported(sums[n])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Printf("not erasing %s, checksum does not match any imported file", files[n].BaseFilename())
+			continue
+		}
+		erase = append(erase, ix)
+	}
+
+	if len(erase) == 0 {
+		return nil
+	}
+
+	log.Printf("erasing %d files", len(erase))
+	scanner, err = g.cmd(exec.Command(bin, "--delete-file", strings.Join(erase, ",")))
+	if err != nil {
+		return err
+	}
+	for scanner.Scan() {
+		log.Println(scanner.Text())
+	}
+
+	return scanner.Close()
+}
+
+func (g *GPhoto2) sum(index string) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	cmd := exec.Command(bin, "--get-file", index, "--stdout")
+	cmd.Stderr = buf
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	_, cs, err := importer.CopySum(io.Discard, r, nil)
+	if werr := cmd.Wait(); werr != nil {
+		return "", fmt.Errorf("%w: %s", werr, buf.String())
+	}
+	return cs, err
+}
+
+func (g *GPhoto2) Tether(log *log.Logger, destination string, imp *importer.Import, stop <-chan struct{}) error {
+	if imp.Verify() {
+		log.Println("[WARN] gphoto2 can not verify tethered captures")
+	}
+
+	cmd := exec.Command(bin, "--wait-event-and-download", "--keep")
+	cmd.Dir = destination
+	scanner, err := g.cmd(cmd)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+			close(stopped)
+			cmd.Process.Signal(os.Interrupt)
+		case <-done:
+		}
+	}()
+
+	for scanner.Scan() {
+		s := scanner.Text()
+		m := savingRE.FindStringSubmatch(s)
+		if len(m) != 2 {
+			log.Println(s)
+			continue
+		}
+
+		fn := filepath.Base(strings.TrimSpace(m[1]))
+		p := filepath.Join(destination, fn)
+		if _, ok := g.exts[strings.ToLower(filepath.Ext(fn))]; !ok {
+			log.Printf("ignoring capture %s", fn)
+			os.Remove(p)
+			continue
+		}
+
+		st, err := os.Stat(p)
+		if err != nil {
+			cmd.Process.Kill()
+			scanner.Close()
+			return err
+		}
+
+		f := importer.NewFile(destination, st.Size(), fn)
+		if err := imp.Add(p, f); err != nil {
+			cmd.Process.Kill()
+			scanner.Close()
 			return err
 		}
 	}
 
-	return nil
+	err = scanner.Close()
+	select {
+	case <-stopped:
+		return nil
+	default:
+		return err
+	}
 }
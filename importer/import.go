@@ -4,39 +4,82 @@ import (
 	"bytes"
 	"crypto/sha512"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/frizinak/phodo/phodo"
+	"github.com/frizinak/photos/meta"
 )
 
 type Exists func(*File, io.ReadSeeker, int64) (bool, error)
-type Add func(src string, dest *File) error
 type Progress func(n, total int)
 
 type Import struct {
 	exists   Exists
-	add      Add
+	add      func(src string, dest *File, sourceSum string) error
 	progress Progress
+	verify   bool
+	erase    bool
+	imported func(sum string) (bool, error)
 }
 
 func (i *Import) Exists(f *File, r io.ReadSeeker, maxProbes int64) (bool, error) {
 	return i.exists(f, r, maxProbes)
 }
-func (i *Import) Add(src string, dest *File) error { return i.add(src, dest) }
+func (i *Import) Add(src string, dest *File) error { return i.add(src, dest, "") }
 func (i *Import) Progress(n, total int)            { i.progress(n, total) }
 
+// Verify reports whether backends should checksum the source while copying
+// (see CopySum) and pass it to AddVerified.
+func (i *Import) Verify() bool { return i.verify }
+
+// AddVerified is Add but the file is not imported if sourceSum does not
+// match the checksum of src.
+func (i *Import) AddVerified(src string, dest *File, sourceSum string) error {
+	return i.add(src, dest, sourceSum)
+}
+
+// Erase reports whether backends should remove source files once they are
+// verified to be part of the library, see Imported.
+func (i *Import) Erase() bool { return i.erase }
+
+// Imported reports whether a file with the given checksum is part of the
+// library, either because it was just imported or was already present.
+func (i *Import) Imported(sum string) (bool, error) {
+	if sum == "" {
+		return false, nil
+	}
+	return i.imported(sum)
+}
+
+// CopySum copies src to dst and returns the checksum of all data read.
+func CopySum(dst io.Writer, src io.Reader, buf []byte) (int64, string, error) {
+	cs := sha512.New()
+	n, err := io.CopyBuffer(io.MultiWriter(dst, cs), src, buf)
+	return n, hex.EncodeToString(cs.Sum(nil)), err
+}
+
 type Backend interface {
 	Available() (bool, error)
 	Import(log *log.Logger, destination string, i *Import) error
 }
 
+// Tetherer is implemented by backends that can import files as they are
+// captured.
+type Tetherer interface {
+	Backend
+	// Tether blocks and imports each new capture until stop is closed.
+	Tether(log *log.Logger, destination string, i *Import, stop <-chan struct{}) error
+}
+
 var (
 	lock     sync.Mutex
 	backends = map[string]Backend{}
@@ -60,11 +103,19 @@ type Importer struct {
 	colDir  string
 	convDir string
 
+	albumDir string
+	pathTmpl *PathTemplate
+
 	phodoConf func() (phodo.Conf, error)
 
 	symlinkSem        sync.RWMutex
 	symlinkCache      map[string][]LinkInfo
 	symlinkCachePaths map[string]map[string]struct{}
+
+	indexSem sync.Mutex
+	idx      *meta.Index
+
+	previews *previewCache
 }
 
 func New(log, verbose *log.Logger, conf func() (phodo.Conf, error), rawDir, colDir, convDir string) *Importer {
@@ -72,7 +123,10 @@ func New(log, verbose *log.Logger, conf func() (phodo.Conf, error), rawDir, colD
 		log:     log,
 		verbose: verbose,
 		rawDir:  rawDir, colDir: colDir, convDir: convDir,
+		albumDir:  DefaultAlbumDir,
+		pathTmpl:  defaultPathTemplate,
 		phodoConf: conf,
+		previews:  newPreviewCache(filepath.Join(rawDir, DefaultPreviewDir), DefaultPreviewCacheSize),
 	}
 	i.ClearCache()
 	return i
@@ -99,134 +153,487 @@ func (i *Importer) RawExtList(n []string) []string   { return RawExtList(n) }
 
 func (i *Importer) ConvDir() string { return i.convDir }
 
-func (i *Importer) Import(checksum bool, progress Progress, timeOverride time.Time) error {
-	os.MkdirAll(i.rawDir, 0755)
+type importRun struct {
+	i            *Importer
+	checksum     bool
+	verify       bool
+	erase        bool
+	timeOverride time.Time
+	log          *importLog
 
-	im := &Import{}
-	im.progress = progress
-	im.exists = func(f *File, r io.ReadSeeker, maxProbes int64) (bool, error) {
-		p := (NewFile(i.rawDir, f.bytes, f.fn)).Path()
-		s, err := os.Stat(p)
-		if os.IsNotExist(err) {
-			if checksum {
-				i.log.Printf("Would import %s from %s", p, f.Path())
-			}
-			return false, nil
+	failedSem sync.Mutex
+	failed    []string
+
+	onImport func(*File) error
+
+	index     *sumIndex
+	indexErr  error
+	indexOnce sync.Once
+}
+
+func (r *importRun) loadSums() (*sumIndex, error) {
+	r.indexOnce.Do(func() { r.index, r.indexErr = r.i.loadSums() })
+	return r.index, r.indexErr
+}
+
+func (r *importRun) entry(state, src string, f *File, cs string, dest *File) importEntry {
+	e := importEntry{state: state, src: src, file: f.Filename(), checksum: cs}
+	if rel, err := filepath.Rel(r.i.rawDir, src); err == nil {
+		e.src = rel
+	}
+	if dest != nil {
+		e.dest = dest.Filename()
+	}
+	return e
+}
+
+func (r *importRun) exists(f *File, rs io.ReadSeeker, maxProbes int64) (bool, error) {
+	i := r.i
+	if r.checksum || r.erase {
+		// every file needs to be checksummed.
+		return false, nil
+	}
+
+	p := (NewFile(i.rawDir, f.bytes, f.fn)).Path()
+	s, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if s.IsDir() || err != nil {
+		if err == nil {
+			err = fmt.Errorf("file '%s' exists as a directory", p)
 		}
+		return false, err
+	}
 
-		if checksum {
-			return false, nil
+	// exists
+	if rs == nil {
+		i.log.Printf("[WARN] skipping %s, exists as %s but file contents were not compared", f.fn, p)
+		return true, nil
+	}
+
+	ex, err := os.Open(p)
+	if err != nil {
+		return true, err
+	}
+	defer ex.Close()
+
+	const probeSize = 1024
+	bufEx := make([]byte, probeSize)
+	bufNw := make([]byte, probeSize)
+	probes := f.bytes / probeSize
+	if probes > maxProbes {
+		probes = maxProbes
+	}
+	if probes < 1 {
+		probes = 1
+	}
+	jump := f.bytes / probes
+
+	var n int64
+	for ; n < f.bytes-probeSize; n += jump {
+		rs.Seek(n, io.SeekStart)
+		ex.Seek(n, io.SeekStart)
+		if _, err := ex.Read(bufEx); err != nil {
+			return false, err
 		}
 
-		if s.IsDir() || err != nil {
-			if err == nil {
-				err = fmt.Errorf("file '%s' exists as a directory", p)
-			}
+		if _, err := rs.Read(bufNw); err != nil {
 			return false, err
 		}
 
-		// exists
-		if r == nil {
-			i.log.Printf("[WARN] skipping %s, exists as %s but file contents were not compared", f.fn, p)
-			return true, nil
+		if !bytes.Equal(bufNw, bufEx) {
+			// Same name and size but different contents, let add
+			// decide based on the checksum.
+			i.verbose.Printf("%s exists as %s but is not identical", f.BasePath(), p)
+			return false, nil
 		}
+	}
 
-		ex, err := os.Open(p)
-		if err != nil {
-			return true, err
+	return true, nil
+}
+
+func (r *importRun) add(src string, f *File, sourceSum string) error {
+	i := r.i
+	if !i.supported(f.fn) {
+		return fmt.Errorf("unsupported extension %s", f.Path())
+	}
+
+	if r.checksum {
+		defer os.Remove(src)
+	} else if err := r.log.write(r.entry(importCopied, src, f, sourceSum, nil)); err != nil {
+		return err
+	}
+
+	sums, err := r.loadSums()
+	if err != nil {
+		return err
+	}
+
+	cs, err := sum(src)
+	if err != nil {
+		return err
+	}
+	if sourceSum != "" && sourceSum != cs {
+		i.log.Printf("[ERR] %s: checksum of copy does not match the source", f.BasePath())
+		r.failedSem.Lock()
+		r.failed = append(r.failed, f.BaseFilename())
+		r.failedSem.Unlock()
+		if r.checksum {
+			return nil
 		}
-		defer ex.Close()
-
-		const probeSize = 1024
-		bufEx := make([]byte, probeSize)
-		bufNw := make([]byte, probeSize)
-		probes := f.bytes / probeSize
-		if probes > maxProbes {
-			probes = maxProbes
+		if err := os.Remove(src); err != nil {
+			return err
 		}
-		jump := f.bytes / probes
-
-		var i int64
-		for ; i < f.bytes-probeSize; i += jump {
-			r.Seek(i, io.SeekStart)
-			ex.Seek(i, io.SeekStart)
-			if _, err := ex.Read(bufEx); err != nil {
-				return false, err
-			}
+		return r.log.write(r.entry(importSkipped, src, f, cs, nil))
+	}
 
-			if _, err := r.Read(bufNw); err != nil {
-				return false, err
-			}
+	if !r.checksum {
+		if err := r.log.write(r.entry(importVerified, src, f, cs, nil)); err != nil {
+			return err
+		}
+	}
 
-			if !bytes.Equal(bufNw, bufEx) {
-				return true, fmt.Errorf(
-					"file %s exists but is not identical to %s\nthe file will not be imported!\nmake a manual backup of this file and wait till a solution is implemented",
-					p,
-					f.BasePath(),
-				)
-			}
+	sums.Lock()
+	if ex, ok := sums.m[cs]; ok {
+		sums.Unlock()
+		i.verbose.Printf("skipping %s, identical to %s", f.Path(), ex.Path())
+		if r.checksum {
+			return nil
+		}
+		if err := os.Remove(src); err != nil {
+			return err
 		}
+		return r.log.write(r.entry(importSkipped, src, f, cs, ex))
+	}
 
-		return true, nil
+	p, err := uniqueFile(i.rawDir, f.bytes, f.fn)
+	if err != nil {
+		sums.Unlock()
+		return err
 	}
 
-	im.add = func(src string, f *File) error {
-		if !i.supported(f.fn) {
-			return fmt.Errorf("unsupported extension %s", f.Path())
+	if r.checksum {
+		sums.Unlock()
+		if p.fn != f.fn {
+			i.log.Printf("Duplicate filename '%s' -> '%s' different checksum, would import as '%s'", f.Path(), NewFile(i.rawDir, f.bytes, f.fn).Path(), p.Path())
+			return nil
 		}
+		i.log.Printf("Would import %s from %s", p.Path(), f.Path())
+		return nil
+	}
+
+	dest := p.Path()
+	i.verbose.Printf("importing %s to %s", f.Path(), dest)
+	if err := os.Rename(src, dest); err != nil {
+		sums.Unlock()
+		return err
+	}
+	sums.m[cs] = p
+	sums.Unlock()
 
-		p := NewFile(i.rawDir, f.bytes, f.fn)
-		dest := p.Path()
-		i.verbose.Printf("importing %s to %s", f.Path(), p.Path())
+	if err := i.created(dest); err != nil {
+		return err
+	}
+	if err := r.log.write(r.entry(importRenamed, src, f, cs, p)); err != nil {
+		return err
+	}
 
-		if checksum {
-			defer os.Remove(src)
-			existing, err := sum(dest)
+	if _, err = makeMeta(p, r.timeOverride, cs); err != nil {
+		return err
+	}
+	if err := r.pair(p); err != nil {
+		return err
+	}
+	if err := r.log.write(r.entry(importMeta, src, f, cs, p)); err != nil {
+		return err
+	}
+	if r.onImport != nil {
+		return r.onImport(p)
+	}
+	return nil
+}
+
+// renamed finds the raw f was renamed to if that happened right before an
+// interruption.
+func (r *importRun) renamed(f *File, cs string) (*File, error) {
+	ext := filepath.Ext(f.fn)
+	stem := f.fn[:len(f.fn)-len(ext)]
+	p := NewFile(r.i.rawDir, f.bytes, f.fn)
+	for n := 1; ; n++ {
+		if _, err := os.Stat(p.Path()); err != nil {
 			if os.IsNotExist(err) {
-				return nil
+				return nil, nil
 			}
-			newfile, err := sum(src)
+			return nil, err
+		}
+		if _, err := GetMeta(p); os.IsNotExist(err) {
+			s, err := sum(p.Path())
 			if err != nil {
-				return err
+				return nil, err
 			}
-			if newfile != existing {
-				i.log.Printf("Duplicate filename '%s' -> '%s' different checksum", f.Path(), dest)
+			if s == cs {
+				return p, nil
 			}
-			return nil
 		}
 
-		err := os.Rename(src, dest)
-		if err != nil {
+		p = NewFile(r.i.rawDir, f.bytes, fmt.Sprintf("%s-%d%s", stem, n, ext))
+	}
+}
+
+// resume finishes the files an interrupted import did not get to.
+func (r *importRun) resume(pending []importEntry) error {
+	i := r.i
+	writeMeta := func(src string, f, p *File, cs string) error {
+		i.log.Printf("resuming import of '%s', writing meta", p.Path())
+		// the interruption might have happened before its creation was
+		// journaled.
+		if err := i.created(p.Path()); err != nil {
+			return err
+		}
+		if _, err := makeMeta(p, r.timeOverride, cs); err != nil {
+			return err
+		}
+		if err := r.pair(p); err != nil {
 			return err
 		}
+		return r.log.write(r.entry(importMeta, src, f, cs, p))
+	}
+
+	// renamed files first so their checksums are known before
+	// the others are compared against them.
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].state == importRenamed && pending[j].state != importRenamed
+	})
+
+	for _, e := range pending {
+		src := filepath.Join(i.rawDir, e.src)
+		f := NewFileFromPath(filepath.Join(filepath.Dir(src), e.file))
+		switch e.state {
+		case importCopied, importVerified:
+			s, err := os.Stat(src)
+			if err == nil && s.Size() == f.Bytes() {
+				i.log.Printf("resuming import of '%s'", src)
+				// copied entries hold the checksum of the source,
+				// verified ones that of the copy which matched it.
+				if e.checksum == "" && r.verify {
+					i.log.Printf("[WARN] %s: the source checksum is unknown, the copy is imported unverified", src)
+				}
+				if err := r.add(src, f, e.checksum); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if e.state == importVerified && os.IsNotExist(err) {
+				p, err := r.renamed(f, e.checksum)
+				if err != nil {
+					return err
+				}
+				if p != nil {
+					if err := writeMeta(src, f, p, e.checksum); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+
+			i.log.Printf("not resuming import of '%s', copy is missing or incomplete", src)
+			os.Remove(src)
+			if err := r.log.write(r.entry(importSkipped, src, f, e.checksum, nil)); err != nil {
+				return err
+			}
+		case importRenamed:
+			p := NewFileFromPath(filepath.Join(i.rawDir, e.dest))
+			if _, err := os.Stat(p.Path()); err != nil {
+				return err
+			}
+			if err := writeMeta(src, f, p, e.checksum); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("invalid state '%s' for '%s' in %s", e.state, e.src, importLogFile)
+		}
+	}
+
+	return nil
+}
+
+func (i *Importer) newImportRun(checksum bool, timeOverride time.Time) (*importRun, []importEntry, error) {
+	r := &importRun{i: i, checksum: checksum, timeOverride: timeOverride}
+	if checksum {
+		return r, nil, nil
+	}
+
+	var pending []importEntry
+	var err error
+	r.log, pending, err = openImportLog(i.importLogPath())
+	return r, pending, err
+}
+
+func (r *importRun) imported(cs string) (bool, error) {
+	sums, err := r.loadSums()
+	if err != nil {
+		return false, err
+	}
+	sums.Lock()
+	f, ok := sums.m[cs]
+	sums.Unlock()
+	if !ok {
+		return false, nil
+	}
+	if _, err := os.Stat(f.Path()); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
 
-		_, err = MakeMeta(p, timeOverride)
+// summary reports all files that failed verification.
+func (r *importRun) summary() error {
+	if len(r.failed) == 0 {
+		return nil
+	}
+	sort.Strings(r.failed)
+	r.i.log.Printf("%d file(s) failed verification and were not imported:", len(r.failed))
+	for _, f := range r.failed {
+		r.i.log.Printf("  %s", f)
+	}
+	return fmt.Errorf("%d file(s) failed verification", len(r.failed))
+}
+
+// Import imports from all registered backends. If verify is true backends
+// that support it checksum the source while copying and files whose copy
+// does not match are not imported. If erase is true source files are removed
+// once their checksum matches a file in the library, this implies verify.
+func (i *Importer) Import(checksum, verify, erase bool, progress Progress, timeOverride time.Time) error {
+	return i.ImportFunc(checksum, verify, erase, progress, timeOverride, nil)
+}
+
+// ImportFunc is Import but cb, if not nil, is called for each newly imported
+// file.
+func (i *Importer) ImportFunc(checksum, verify, erase bool, progress Progress, timeOverride time.Time, cb func(*File) error) error {
+	os.MkdirAll(i.rawDir, 0755)
+
+	run, pending, err := i.newImportRun(checksum, timeOverride)
+	if err != nil {
+		return err
+	}
+	run.erase = erase && !checksum
+	run.verify = verify || run.erase
+	run.onImport = cb
+	defer run.log.close()
+
+	if err := run.resume(pending); err != nil {
 		return err
 	}
 
+	im := &Import{verify: run.verify, erase: run.erase}
+	im.progress = progress
+	im.imported = run.imported
+	im.exists = run.exists
+	im.add = run.add
+
 	lock.Lock()
 	defer lock.Unlock()
 	for n, b := range backends {
 		tmpdest := fmt.Sprintf("%s/tmp-%s", i.rawDir, clean(n))
 		os.RemoveAll(tmpdest)
 		os.MkdirAll(tmpdest, 0700)
-		defer os.RemoveAll(tmpdest)
 		ok, err := b.Available()
 		if err != nil {
 			return err
 		}
 
 		if !ok {
+			os.RemoveAll(tmpdest)
 			continue
 		}
 
 		i.log.Printf("Importing with %s", n)
 		if err := b.Import(i.verbose, tmpdest, im); err != nil {
+			// keep the copies so the next run can resume.
+			run.summary()
 			return err
 		}
+		os.RemoveAll(tmpdest)
 	}
 
-	return nil
+	if err := run.log.finish(); err != nil {
+		return err
+	}
+	return run.summary()
+}
+
+// Tether imports new captures from the first available registered backend
+// that supports tethering until stop is closed. cb is called for each
+// imported file.
+func (i *Importer) Tether(verify bool, cb func(*File) error, stop <-chan struct{}) error {
+	os.MkdirAll(i.rawDir, 0755)
+
+	run, pending, err := i.newImportRun(false, time.Time{})
+	if err != nil {
+		return err
+	}
+	run.verify = verify
+	run.onImport = cb
+	defer run.log.close()
+
+	if err := run.resume(pending); err != nil {
+		return err
+	}
+
+	im := &Import{verify: run.verify}
+	im.progress = func(n, total int) {}
+	im.exists = run.exists
+	im.add = run.add
+
+	lock.Lock()
+	names := make([]string, 0, len(backends))
+	for n := range backends {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var backend Tetherer
+	var name string
+	for _, n := range names {
+		t, ok := backends[n].(Tetherer)
+		if !ok {
+			continue
+		}
+		avail, err := t.Available()
+		if err != nil {
+			lock.Unlock()
+			return err
+		}
+		if avail {
+			backend, name = t, n
+			break
+		}
+	}
+	lock.Unlock()
+
+	if backend == nil {
+		return errors.New("no camera available for tethering")
+	}
+
+	tmpdest := fmt.Sprintf("%s/tmp-tether-%s", i.rawDir, clean(name))
+	os.RemoveAll(tmpdest)
+	os.MkdirAll(tmpdest, 0700)
+	i.log.Printf("Tethering with %s", name)
+	if err := backend.Tether(i.verbose, tmpdest, im, stop); err != nil {
+		run.summary()
+		return err
+	}
+	os.RemoveAll(tmpdest)
+
+	if err := run.log.finish(); err != nil {
+		return err
+	}
+	return run.summary()
 }
 
 func (i *Importer) AllCounted(it func(f *File, n, total int) (bool, error)) error {
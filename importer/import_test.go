@@ -0,0 +1,42 @@
+package importer
+
+import (
+	"io"
+	"log"
+	"testing"
+)
+
+type tetherBackend struct{ verify bool }
+
+func (t *tetherBackend) Available() (bool, error) { return true, nil }
+
+func (t *tetherBackend) Import(log *log.Logger, destination string, i *Import) error {
+	return nil
+}
+
+func (t *tetherBackend) Tether(log *log.Logger, destination string, i *Import, stop <-chan struct{}) error {
+	t.verify = i.Verify()
+	return nil
+}
+
+func TestTetherVerify(t *testing.T) {
+	b := &tetherBackend{}
+	Register("test", b)
+	defer func() {
+		lock.Lock()
+		delete(backends, "test")
+		lock.Unlock()
+	}()
+
+	l := log.New(io.Discard, "", 0)
+	dir := t.TempDir()
+	i := New(l, l, nil, dir, dir, dir)
+	for _, verify := range []bool{true, false} {
+		if err := i.Tether(verify, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if b.verify != verify {
+			t.Errorf("expected Verify() to be %t", verify)
+		}
+	}
+}
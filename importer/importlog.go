@@ -0,0 +1,156 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const importLogFile = ".import.log"
+
+// states a file goes through while being imported. skipped and meta are
+// final.
+const (
+	importCopied   = "copied"
+	importVerified = "verified"
+	importRenamed  = "renamed"
+	importMeta     = "meta"
+	importSkipped  = "skipped"
+)
+
+type importEntry struct {
+	state string
+	// src is the path of the copy relative to the raw dir.
+	src  string
+	file string
+	// checksum is that of the copy, for copied entries it is that of the
+	// source, if it was verified.
+	checksum string
+	// dest is the filename of the raw once renamed.
+	dest string
+}
+
+func (e importEntry) done() bool { return e.state == importMeta || e.state == importSkipped }
+
+func (e importEntry) String() string {
+	return strings.Join([]string{e.state, e.src, e.file, e.checksum, e.dest}, "\t")
+}
+
+// importLog is an append only log of the state of each file being imported
+// allowing an interrupted import to be resumed.
+type importLog struct {
+	sync.Mutex
+	path string
+	f    *os.File
+}
+
+func readImportLog(path string) (map[string]importEntry, error) {
+	entries := make(map[string]importEntry)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return entries, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		p := strings.Split(s.Text(), "\t")
+		if len(p) != 5 {
+			// a torn write, the previous state of this entry is still
+			// valid.
+			continue
+		}
+		e := importEntry{state: p[0], src: p[1], file: p[2], checksum: p[3], dest: p[4]}
+		entries[e.src] = e
+	}
+
+	return entries, s.Err()
+}
+
+// openImportLog returns the log and all unfinished entries, finished entries
+// are dropped from the log.
+func openImportLog(path string) (*importLog, []importEntry, error) {
+	entries, err := readImportLog(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pending := make([]importEntry, 0)
+	for _, e := range entries {
+		if !e.done() {
+			pending = append(pending, e)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].src < pending[j].src })
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range pending {
+		fmt.Fprintln(w, e)
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, nil, err
+	}
+
+	l := &importLog{path: path}
+	l.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	return l, pending, err
+}
+
+func (l *importLog) write(e importEntry) error {
+	if l == nil {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	_, err := fmt.Fprintln(l.f, e)
+	return err
+}
+
+func (l *importLog) close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// finish removes the log if no entries are pending.
+func (l *importLog) finish() error {
+	if l == nil {
+		return nil
+	}
+	if err := l.close(); err != nil {
+		return err
+	}
+	entries, err := readImportLog(l.path)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.done() {
+			return nil
+		}
+	}
+	return os.Remove(l.path)
+}
+
+func (i *Importer) importLogPath() string { return filepath.Join(i.rawDir, importLogFile) }
@@ -0,0 +1,81 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/frizinak/photos/meta"
+)
+
+func (i *Importer) index() (*meta.Index, error) {
+	i.indexSem.Lock()
+	defer i.indexSem.Unlock()
+	if i.idx != nil {
+		return i.idx, nil
+	}
+
+	idx, err := meta.OpenIndex(i.rawDir)
+	if os.IsNotExist(err) {
+		idx, err = i.rebuildIndex()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	i.idx = idx
+	return idx, nil
+}
+
+func (i *Importer) rebuildIndex() (*meta.Index, error) {
+	i.log.Println("building meta index")
+	idx := meta.NewIndex(i.rawDir)
+	err := i.All(func(f *File) (bool, error) {
+		m, err := GetMeta(f)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return true, nil
+			}
+			return false, err
+		}
+		idx.Set(filepath.Base(metaFile(f)), m)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return idx, idx.Save()
+}
+
+// RebuildIndex rereads all .meta files, use this after .meta files were
+// modified by something other than photos.
+func (i *Importer) RebuildIndex() error {
+	i.indexSem.Lock()
+	defer i.indexSem.Unlock()
+	idx, err := i.rebuildIndex()
+	if err != nil {
+		return err
+	}
+	i.idx = idx
+	return nil
+}
+
+// Meta is the indexed equivalent of GetMeta.
+func (i *Importer) Meta(f *File) (meta.Meta, error) {
+	idx, err := i.index()
+	if err != nil {
+		return meta.Meta{}, err
+	}
+
+	key := filepath.Base(metaFile(f))
+	if m, ok := idx.Get(key); ok {
+		return m, nil
+	}
+
+	m, err := GetMeta(f)
+	if err != nil {
+		return m, err
+	}
+
+	return m, idx.Add(key, m)
+}
@@ -0,0 +1,57 @@
+package importer
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/frizinak/photos/journal"
+	"github.com/frizinak/photos/meta"
+)
+
+func (i *Importer) remove(path string) error {
+	return journal.Remove(i.rawDir, path)
+}
+
+// created records an imported raw so undoing the import trashes it.
+func (i *Importer) created(path string) error {
+	return journal.Create(i.rawDir, path)
+}
+
+func (i *Importer) Sessions() ([]journal.Session, error) {
+	return journal.Sessions(i.rawDir)
+}
+
+func (i *Importer) Undo(session int64) ([]string, error) {
+	paths, err := journal.Undo(i.rawDir, session)
+	rebuild := false
+	for _, p := range paths {
+		if !strings.HasSuffix(p, ".meta") {
+			continue
+		}
+
+		m, lerr := meta.Load(p)
+		if lerr != nil {
+			rebuild = true
+			break
+		}
+
+		idx, ierr := i.index()
+		if ierr != nil {
+			return paths, ierr
+		}
+		if ierr = idx.Add(filepath.Base(p), m); ierr != nil {
+			return paths, ierr
+		}
+	}
+
+	i.ClearCache()
+	if rebuild {
+		if rerr := i.RebuildIndex(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+
+	return paths, err
+}
+
+func (i *Importer) EmptyTrash() error { return journal.Purge(i.rawDir) }
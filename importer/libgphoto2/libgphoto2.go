@@ -4,6 +4,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -135,19 +136,94 @@ func (l *LibGPhoto2) Import(
 			return err
 		}
 		r := l.cam.ReadSeeker(f.dir, f.name)
-		_, err = io.CopyBuffer(w, r, buf)
+		var cs string
+		if imp.Verify() {
+			_, cs, err = importer.CopySum(w, r, buf)
+		} else {
+			_, err = io.CopyBuffer(w, r, buf)
+		}
 		r.Close()
 		w.Close()
 		if err != nil {
 			return err
 		}
 
-		if err := imp.Add(src, f.f); err != nil {
+		if err := imp.AddVerified(src, f.f, cs); err != nil {
 			return err
 		}
+		if imp.Erase() {
+			ok, err := imp.Imported(cs)
+			if err != nil {
+				return err
+			}
+			if ok {
+				log.Printf("erasing %s", path.Join(f.dir, f.name))
+				if err := l.cam.DeleteFile(f.dir, f.name); err != nil {
+					return err
+				}
+			}
+		}
 		done++
 		prog()
 	}
 
 	return nil
 }
+
+func (l *LibGPhoto2) Tether(
+	log *log.Logger,
+	destination string,
+	imp *importer.Import,
+	stop <-chan struct{},
+) error {
+	defer l.close()
+	if err := l.init(); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1024*1024*100)
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		// don't abandon a pending wait, the camera is closed on return.
+		ev := <-l.cam.AsyncWaitForEvent(1000)
+		if ev == nil || ev.File == "" {
+			continue
+		}
+		if _, ok := l.exts[strings.ToLower(filepath.Ext(ev.File))]; !ok {
+			log.Printf("ignoring capture %s", path.Join(ev.Folder, ev.File))
+			continue
+		}
+
+		info, err := l.cam.Info(ev.Folder, ev.File)
+		if err != nil {
+			return err
+		}
+
+		f := importer.NewFile(destination, info.Size, ev.File)
+		w, err := os.Create(f.Path())
+		if err != nil {
+			return err
+		}
+		r := l.cam.ReadSeeker(ev.Folder, ev.File)
+		var cs string
+		if imp.Verify() {
+			_, cs, err = importer.CopySum(w, r, buf)
+		} else {
+			_, err = io.CopyBuffer(w, r, buf)
+		}
+		r.Close()
+		w.Close()
+		if err != nil {
+			return err
+		}
+
+		if err := imp.AddVerified(f.Path(), f, cs); err != nil {
+			return err
+		}
+	}
+}
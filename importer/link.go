@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/frizinak/photos/meta"
 )
@@ -124,7 +125,7 @@ func (i *Importer) linkCache(dir string) error {
 			}
 
 			if meta.Deleted {
-				if err := os.Remove(path); err != nil {
+				if err := i.remove(path); err != nil {
 					return false, err
 				}
 			}
@@ -134,7 +135,10 @@ func (i *Importer) linkCache(dir string) error {
 				LinkInfo{dir: filepath.Dir(path), fn: fn, link: file},
 			)
 
-			i.symlinkCachePaths[dir][file.Path()] = struct{}{}
+			// a file that is only part of an album still needs a regular link.
+			if !i.isAlbumLink(path) {
+				i.symlinkCachePaths[dir][file.Path()] = struct{}{}
+			}
 			return true, nil
 		})
 		if err != nil {
@@ -169,21 +173,15 @@ func Abs(path string) (string, error) {
 	return filepath.Abs(rp)
 }
 
+// NicePath returns the default link path of f in dir, see
+// DefaultPathTemplate.
 func NicePath(dir string, f *File, meta meta.Meta) string {
-	d := meta.CreatedTime()
-	return filepath.Join(
-		dir,
-		d.Format("2006"),
-		d.Format("01-02 Mon"),
-		"misc",
-		fmt.Sprintf(
-			"%s--%s",
-			d.Format("2006-01-02-15-04"),
-			f.BaseFilename(),
-		),
-	)
+	return defaultPathTemplate.Path(dir, f, meta)
 }
 
+// SetPathTemplate sets the layout new links are created with.
+func (i *Importer) SetPathTemplate(t *PathTemplate) { i.pathTmpl = t }
+
 func (i *Importer) Link(f *File) error {
 	real, err := Abs(f.Path())
 	if err != nil {
@@ -206,7 +204,7 @@ func (i *Importer) Link(f *File) error {
 		return nil
 	}
 
-	linkDest := NicePath(i.colDir, f, meta)
+	linkDest := i.pathTmpl.Path(i.colDir, f, meta)
 	linkDir := filepath.Dir(linkDest)
 	os.MkdirAll(linkDir, 0755)
 	linkDir, err = Abs(linkDir)
@@ -227,3 +225,51 @@ func (i *Importer) Link(f *File) error {
 
 	return nil
 }
+
+// Relink moves the regular (i.e.: non-album) link of f to the path dictated by
+// the path template, sidecars are moved along.
+func (i *Importer) Relink(f *File) error {
+	links, err := i.FindLinks(f)
+	if err != nil {
+		return err
+	}
+
+	regular := make([]string, 0, len(links))
+	for _, l := range links {
+		if !i.isAlbumLink(l) {
+			regular = append(regular, l)
+		}
+	}
+	if len(regular) == 0 {
+		return nil
+	}
+
+	m, err := GetMeta(f)
+	if err != nil {
+		return err
+	}
+
+	dest := i.pathTmpl.Path(i.colDir, f, m)
+	sort.Strings(regular)
+	for _, l := range regular {
+		if l == dest {
+			return nil
+		}
+	}
+	if len(regular) > 1 {
+		i.log.Printf("%s has %d links, only moving '%s'", f.Filename(), len(regular), regular[0])
+	}
+
+	if _, err := os.Lstat(dest); err == nil {
+		i.log.Printf("not moving '%s', '%s' already exists", regular[0], dest)
+		return nil
+	}
+
+	return i.moveLink(regular[0], dest)
+}
+
+// CleanLinkDirs removes all empty directories in the collection directory.
+func (i *Importer) CleanLinkDirs() error {
+	_, err := rmEmpty(i.colDir)
+	return err
+}
@@ -13,6 +13,10 @@ func metaFile(f *File) string {
 }
 
 func MakeMeta(f *File, date time.Time) (meta.Meta, error) {
+	return makeMeta(f, date, "")
+}
+
+func makeMeta(f *File, date time.Time, checksum string) (meta.Meta, error) {
 	m, err := GetMeta(f)
 	if err != nil && !os.IsNotExist(err) {
 		return m, err
@@ -20,11 +24,13 @@ func MakeMeta(f *File, date time.Time) (meta.Meta, error) {
 
 	if err != nil {
 		m = meta.New(f.Bytes(), f.BaseFilename(), f.Filename())
-		m.Checksum, err = sum(f.Path())
-		if err != nil {
-			return m, err
+		m.Checksum = checksum
+		if m.Checksum == "" {
+			m.Checksum, err = sum(f.Path())
+			if err != nil {
+				return m, err
+			}
 		}
-
 	}
 
 	p := tags.ParseExif
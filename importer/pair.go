@@ -0,0 +1,191 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/frizinak/photos/meta"
+)
+
+func pairKey(f *File) string {
+	fn := strings.ToLower(f.BaseFilename())
+	return fn[:len(fn)-len(filepath.Ext(fn))]
+}
+
+// pairable reports whether a and b are the RAW and JPEG of a single capture.
+func pairable(a *File, am meta.Meta, b *File, bm meta.Meta) bool {
+	if am.Pair != "" || bm.Pair != "" {
+		return false
+	}
+	if !(a.TypeRAW() && b.TypeImage()) && !(a.TypeImage() && b.TypeRAW()) {
+		return false
+	}
+	d := am.Created - bm.Created
+	return d >= -1 && d <= 1
+}
+
+// PairFile returns the other half of the RAW+JPEG capture f is part of or
+// nil.
+func PairFile(f *File, m meta.Meta) *File {
+	if m.Pair == "" {
+		return nil
+	}
+	return NewFileFromPath(filepath.Join(f.dir, m.Pair))
+}
+
+func pairSynced(a, b meta.Meta) bool {
+	return a.Rating == b.Rating &&
+		a.Deleted == b.Deleted &&
+		a.Label == b.Label &&
+		a.Stack == b.Stack &&
+		a.Pick == b.Pick &&
+		strEqual(a.Tags.Unique(), b.Tags.Unique())
+}
+
+// SyncPair copies the rating, deleted state, label, tags and stack in m to
+// the meta of the other half of the pair f is part of. Reports whether it was
+// changed.
+func SyncPair(f *File, m meta.Meta) (bool, error) {
+	p := PairFile(f, m)
+	if p == nil {
+		return false, nil
+	}
+	pm, err := GetMeta(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if pairSynced(m, pm) {
+		return false, nil
+	}
+
+	pm.Rating = m.Rating
+	pm.Deleted = m.Deleted
+	pm.Label = m.Label
+	pm.Tags = append(make(meta.Tags, 0, len(m.Tags)), m.Tags...)
+	pm.Stack, pm.Pick = m.Stack, m.Pick
+	return true, SaveMeta(p, pm)
+}
+
+// syncPair propagates changes to f's meta to its pair unless the meta of
+// the pair is more recent.
+func (i *Importer) syncPair(f *File) error {
+	m, err := GetMeta(f)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	p := PairFile(f, m)
+	if p == nil {
+		return nil
+	}
+
+	fst, err := os.Stat(metaFile(f))
+	if err != nil {
+		return err
+	}
+	pst, err := os.Stat(metaFile(p))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fst.ModTime().Before(pst.ModTime()) {
+		return nil
+	}
+
+	changed, err := SyncPair(f, m)
+	if err != nil || !changed {
+		return err
+	}
+	i.verbose.Printf("sync meta to pair %s from %s", p.Path(), f.Path())
+	return i.SyncMetaAndPP3(p)
+}
+
+// CollapsePairs removes the JPEG half of each pair whose RAW is part of
+// files as well.
+func (i *Importer) CollapsePairs(files []*File) ([]*File, error) {
+	all := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		all[f.Path()] = struct{}{}
+	}
+
+	n := make([]*File, 0, len(files))
+	for _, f := range files {
+		if !f.TypeImage() {
+			n = append(n, f)
+			continue
+		}
+		m, err := i.Meta(f)
+		if err != nil && !os.IsNotExist(err) {
+			return n, err
+		}
+		if p := PairFile(f, m); p != nil && p.TypeRAW() {
+			if _, ok := all[p.Path()]; ok {
+				continue
+			}
+		}
+		n = append(n, f)
+	}
+
+	return n, nil
+}
+
+// pair links f to a previously imported file that is the other half of its
+// RAW+JPEG capture.
+func (r *importRun) pair(f *File) error {
+	if !f.TypeRAW() && !f.TypeImage() {
+		return nil
+	}
+	m, err := GetMeta(f)
+	if err != nil || m.Pair != "" {
+		return err
+	}
+
+	sums, err := r.loadSums()
+	if err != nil {
+		return err
+	}
+	sums.Lock()
+	defer sums.Unlock()
+
+	key := pairKey(f)
+	for _, c := range sums.stems[key] {
+		if c.Path() == f.Path() {
+			continue
+		}
+		cm, err := GetMeta(c)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if !pairable(f, m, c, cm) {
+			continue
+		}
+
+		r.i.verbose.Printf("pairing %s with %s", f.Path(), c.Path())
+		m.Pair, cm.Pair = c.Filename(), f.Filename()
+		if err := SaveMeta(c, cm); err != nil {
+			return err
+		}
+		if err := SaveMeta(f, m); err != nil {
+			return err
+		}
+		// the previously imported half might have been rated already.
+		if _, err := SyncPair(c, cm); err != nil {
+			return err
+		}
+		break
+	}
+	sums.stems[key] = append(sums.stems[key], f)
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package importer
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"os"
+)
+
+// PHash returns the difference hash of img: img is reduced to 9x8 grayscale
+// pixels and each bit is set if a pixel is brighter than its right
+// neighbour.
+func PHash(img image.Image) uint64 {
+	const w, h = 9, 8
+	var sum, n [w * h]float64
+
+	b := img.Bounds()
+	if b.Empty() {
+		return 0
+	}
+	// sampling ~256 pixels along the shortest side is plenty.
+	step := b.Dx()
+	if b.Dy() < step {
+		step = b.Dy()
+	}
+	step /= 256
+	if step < 1 {
+		step = 1
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		cy := (y - b.Min.Y) * h / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x += step {
+			cx := (x - b.Min.X) * w / b.Dx()
+			r, g, bl, _ := img.At(x, y).RGBA()
+			sum[cy*w+cx] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+			n[cy*w+cx]++
+		}
+	}
+	for i := range sum {
+		if n[i] != 0 {
+			sum[i] /= n[i]
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if sum[y*w+x] > sum[y*w+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// Hamming returns the amount of bits that differ between two hashes.
+func Hamming(a, b uint64) int { return bits.OnesCount64(a ^ b) }
+
+// PHash returns the perceptual hash of the preview of f, it is calculated
+// and stored in its meta if it is not known yet.
+func (i *Importer) PHash(f *File) (uint64, error) {
+	m, err := i.Meta(f)
+	if err != nil {
+		return 0, err
+	}
+	if m.PHashed {
+		return m.PHash, nil
+	}
+	return i.updatePHash(f)
+}
+
+func (i *Importer) updatePHash(f *File) (uint64, error) {
+	r, err := i.GetPreview(f, PreviewThumb)
+	if err != nil {
+		return 0, err
+	}
+	img, _, err := image.Decode(r)
+	r.Close()
+	if err != nil {
+		return 0, fmt.Errorf("could not decode preview of %s: %w", f.Path(), err)
+	}
+
+	m, err := GetMeta(f)
+	if err != nil {
+		return 0, err
+	}
+	h := PHash(img)
+	if m.PHashed && m.PHash == h {
+		return h, nil
+	}
+	m.PHash, m.PHashed = h, true
+	return h, SaveMeta(f, m)
+}
+
+// Similar clusters files of which the perceptual hashes of their previews
+// differ at most dist bits. Files without a preview are skipped, as is the
+// JPEG half of a pair if its RAW is part of files.
+func (i *Importer) Similar(files []*File, dist int) ([][]*File, error) {
+	files, err := i.CollapsePairs(files)
+	if err != nil {
+		return nil, err
+	}
+
+	hashed := make([]*File, 0, len(files))
+	hashes := make([]uint64, 0, len(files))
+	for _, f := range files {
+		h, err := i.PHash(f)
+		if err != nil {
+			if os.IsNotExist(err) {
+				i.verbose.Printf("skipping %s, no preview", f.Path())
+				continue
+			}
+			return nil, err
+		}
+		hashed = append(hashed, f)
+		hashes = append(hashes, h)
+	}
+
+	parent := make([]int, len(hashed))
+	for n := range parent {
+		parent[n] = n
+	}
+	var root func(n int) int
+	root = func(n int) int {
+		if parent[n] != n {
+			parent[n] = root(parent[n])
+		}
+		return parent[n]
+	}
+	for a := range hashes {
+		for b := a + 1; b < len(hashes); b++ {
+			if Hamming(hashes[a], hashes[b]) <= dist {
+				parent[root(b)] = root(a)
+			}
+		}
+	}
+
+	index := make(map[int]int)
+	clusters := make([][]*File, 0)
+	for n, f := range hashed {
+		r := root(n)
+		c, ok := index[r]
+		if !ok {
+			c = len(clusters)
+			index[r] = c
+			clusters = append(clusters, nil)
+		}
+		clusters[c] = append(clusters[c], f)
+	}
+
+	l := clusters[:0]
+	for _, c := range clusters {
+		if len(c) > 1 {
+			l = append(l, c)
+		}
+	}
+	return l, nil
+}
@@ -94,11 +94,28 @@ func (i *Importer) MetaToPP3(link string) error {
 	return pp.Save()
 }
 
+type syncer struct {
+	name     string
+	path     func(link string) string
+	toMeta   func(link string) error
+	fromMeta func(link string) error
+}
+
+func (i *Importer) syncers() []syncer {
+	return []syncer{
+		{"pp3", i.pp3Path, i.PP3ToMeta, i.MetaToPP3},
+		{"xmp", i.xmpPath, i.XMPToMeta, i.MetaToXMP},
+	}
+}
+
 type mtime struct {
 	file string
+	side syncer
 	time time.Time
 }
 
+func (m mtime) path() string { return m.side.path(m.file) }
+
 type mtimes []mtime
 
 func (m mtimes) Less(i, j int) bool { return m[i].time.Before(m[j].time) }
@@ -132,29 +149,29 @@ func (i *Importer) syncMetaAndPP3(f *File) (bool, []string, error) {
 		metaUpdate = metaStat.ModTime()
 	}
 
-	mt := make(mtimes, len(links))
-	for n, link := range links {
-		pp3Path := fmt.Sprintf("%s.pp3", link)
-		var pp3Update time.Time
-
-		pp3Stat, err := os.Stat(pp3Path)
-		if err != nil {
-			pp3Stat = nil
-			if !os.IsNotExist(err) {
-				return false, nil, err
+	syncers := i.syncers()
+	mt := make(mtimes, 0, len(links)*len(syncers))
+	for _, link := range links {
+		for _, side := range syncers {
+			v := mtime{file: link, side: side}
+			stat, err := os.Stat(v.path())
+			if err != nil {
+				if !os.IsNotExist(err) {
+					return false, nil, err
+				}
 			}
-		}
 
-		if pp3Stat != nil {
-			pp3Update = pp3Stat.ModTime()
+			if stat != nil {
+				v.time = stat.ModTime()
+			}
+			mt = append(mt, v)
 		}
-		mt[n] = mtime{link, pp3Update}
 	}
 
 	sort.Sort(mt)
 	list := make([]string, 0, len(mt))
 	for n := range mt {
-		list = append(list, mt[n].file)
+		list = append(list, mt[n].path())
 	}
 
 	last := mt[len(mt)-1]
@@ -169,9 +186,9 @@ func (i *Importer) syncMetaAndPP3(f *File) (bool, []string, error) {
 	changed := false
 	switch {
 	case last.time.After(metaUpdate):
-		i.verbose.Printf("sync pp3 to meta from %s to %s", last.file, f.Path())
+		i.verbose.Printf("sync %s to meta from %s to %s", last.side.name, last.file, f.Path())
 		changed = true
-		if err := i.PP3ToMeta(last.file); err != nil {
+		if err := last.side.toMeta(last.file); err != nil {
 			return changed, list, err
 		}
 		for n := 0; n < len(mt)-1; n++ {
@@ -188,18 +205,18 @@ func (i *Importer) syncMetaAndPP3(f *File) (bool, []string, error) {
 	uniq := make(map[string]struct{}, len(meta2pp3))
 	m2p := make(mtimes, 0, len(meta2pp3))
 	for _, v := range meta2pp3 {
-		if _, ok := uniq[v.file]; ok {
+		if _, ok := uniq[v.path()]; ok {
 			continue
 		}
 
-		uniq[v.file] = struct{}{}
+		uniq[v.path()] = struct{}{}
 		m2p = append(m2p, v)
 	}
 
 	changed = true
 	for _, v := range m2p {
-		i.verbose.Printf("sync meta to pp3 from %s to %s", f.Path(), v.file)
-		if err := i.MetaToPP3(v.file); err != nil {
+		i.verbose.Printf("sync meta to %s from %s to %s", v.side.name, f.Path(), v.file)
+		if err := v.side.fromMeta(v.file); err != nil {
 			return changed, list, err
 		}
 	}
@@ -217,15 +234,7 @@ func (i *Importer) SyncMetaAndPP3(f *File) error {
 		return err
 	}
 
-	files := []string{metaFile(f)}
-	for _, path := range paths {
-		pp3 := path + ".pp3"
-		files = append(files, pp3)
-		if err != nil {
-			return err
-		}
-	}
-
+	files := append([]string{metaFile(f)}, paths...)
 	if changed {
 		now := time.Now().Local()
 		for _, f := range files {
@@ -235,7 +244,7 @@ func (i *Importer) SyncMetaAndPP3(f *File) error {
 		}
 	}
 
-	return nil
+	return i.syncPair(f)
 }
 
 func strEqual(a, b []string) bool {
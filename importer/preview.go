@@ -11,6 +11,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -290,22 +291,40 @@ func (vid *VidPreviewGen) Make(i *Importer, f *File, output string) error {
 	return os.Rename(tmp, output)
 }
 
-func PreviewFile(f *File) string {
-	return f.Path() + ".preview"
-}
-
 func (i *Importer) MakePreview(f *File) error {
+	key := f.Filename()
+	output := i.previews.path(key, PreviewFull, "")
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return err
+	}
+
 	for _, g := range pgens {
 		if g.Supports(f) {
-			return g.Make(i, f, PreviewFile(f))
+			err := g.Make(i, f, output)
+			if errors.Is(err, ErrPreviewNotPossible) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := i.makeTiers(key, ""); err != nil {
+				return err
+			}
+			// previews used to be stored next to the raw.
+			os.Remove(f.Path() + ".preview")
+			if _, err := i.updatePHash(f); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
 		}
 	}
 
 	return ErrPreviewNotPossible
 }
 
-func GetPreview(f *File) (io.ReadCloser, error) {
-	return os.Open(PreviewFile(f))
+// GetPreview opens the cached preview of f in the given tier.
+func (i *Importer) GetPreview(f *File, t PreviewTier) (io.ReadCloser, error) {
+	return i.previews.open(i.PreviewFile(f, t))
 }
 
 func (i *Importer) HasPreview(f *File) (exists, possible bool) {
@@ -320,20 +339,17 @@ func (i *Importer) HasPreview(f *File) (exists, possible bool) {
 		return false, false
 	}
 
-	fh, err := GetPreview(f)
-	if fh != nil {
-		fh.Close()
+	for _, t := range PreviewTiers {
+		if _, err := os.Stat(i.PreviewFile(f, t)); err != nil {
+			return false, true
+		}
 	}
-	return err == nil, true
+	return true, true
 }
 
 func (i *Importer) EnsurePreview(f *File) error {
-	p, err := GetPreview(f)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return i.MakePreview(f)
-		}
-		return err
+	if ex, _ := i.HasPreview(f); ex {
+		return nil
 	}
-	return p.Close()
+	return i.MakePreview(f)
 }
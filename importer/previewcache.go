@@ -0,0 +1,246 @@
+package importer
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPreviewDir is the directory, relative to the raw directory,
+	// previews are cached in.
+	DefaultPreviewDir = ".previews"
+
+	// DefaultPreviewCacheSize is the default maximum size of the preview
+	// cache in bytes.
+	DefaultPreviewCacheSize = 2 << 30
+)
+
+// PreviewTier is a named preview size.
+type PreviewTier struct {
+	Name string
+	// Size is the maximum width and height, 0 keeps the size the PreviewGen
+	// rendered.
+	Size int
+}
+
+var (
+	PreviewFull   = PreviewTier{"full", 0}
+	PreviewScreen = PreviewTier{"screen", 1920}
+	PreviewThumb  = PreviewTier{"thumb", 256}
+
+	// PreviewTiers are ordered from large to small, each tier is scaled
+	// down from the previous one.
+	PreviewTiers = []PreviewTier{PreviewFull, PreviewScreen, PreviewThumb}
+)
+
+// previewCache is a directory of previews per tier, the least recently
+// used ones are removed once its size exceeds max.
+type previewCache struct {
+	sync.Mutex
+	dir  string
+	max  int64
+	size int64
+}
+
+func newPreviewCache(dir string, max int64) *previewCache {
+	return &previewCache{dir: dir, max: max, size: -1}
+}
+
+// SetPreviewCache sets the directory previews are cached in and its maximum
+// size in bytes (<= 0: unlimited).
+func (i *Importer) SetPreviewCache(dir string, max int64) {
+	i.previews = newPreviewCache(dir, max)
+}
+
+// path returns the path of a cached preview, key is relative to the tier
+// directory and hash identifies the sidecar the preview was rendered with.
+func (c *previewCache) path(key string, t PreviewTier, hash string) string {
+	fn := key + ".jpg"
+	if hash != "" {
+		fn = fmt.Sprintf("%s.%s.jpg", key, hash)
+	}
+	return filepath.Join(c.dir, t.Name, fn)
+}
+
+// open opens a cached preview and marks it as used.
+func (c *previewCache) open(path string) (io.ReadCloser, error) {
+	fh, err := os.Open(path)
+	if err == nil {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+	}
+	return fh, err
+}
+
+// stale removes the previews of key with a hash other than the given one.
+func (c *previewCache) stale(key string, hash string) error {
+	prefix := filepath.Base(key) + "."
+	for _, t := range PreviewTiers {
+		dir := filepath.Dir(filepath.Join(c.dir, t.Name, key))
+		items, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, item := range items {
+			n := item.Name()
+			if !strings.HasPrefix(n, prefix) || !strings.HasSuffix(n, ".jpg") {
+				continue
+			}
+			if h := n[len(prefix) : len(n)-4]; h == hash || strings.Contains(h, ".") {
+				continue
+			}
+			if err := os.Remove(filepath.Join(dir, n)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// add accounts for n newly written bytes and prunes the cache if needed.
+func (c *previewCache) add(n int64) error {
+	if c.max <= 0 {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.size < 0 {
+		if err := c.prune(); err != nil {
+			return err
+		}
+	}
+	c.size += n
+	if c.size <= c.max {
+		return nil
+	}
+	return c.prune()
+}
+
+// prune removes the least recently used previews until the cache is 10%
+// below its maximum size.
+func (c *previewCache) prune() error {
+	type entry struct {
+		path string
+		size int64
+		used time.Time
+	}
+	entries := make([]entry, 0)
+	var total int64
+	err := filepath.WalkDir(c.dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		s, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += s.Size()
+		entries = append(entries, entry{path, s.Size(), s.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.size = total
+	if total <= c.max {
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].used.Before(entries[j].used) })
+	target := c.max / 10 * 9
+	for _, e := range entries {
+		if c.size <= target {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		c.size -= e.size
+	}
+	return nil
+}
+
+// PreviewFile returns the path of the cached preview of f in the given tier.
+func (i *Importer) PreviewFile(f *File, t PreviewTier) string {
+	return i.previews.path(f.Filename(), t, "")
+}
+
+// makeTiers scales the full tier down to all other tiers.
+func (i *Importer) makeTiers(key, hash string) error {
+	src := i.previews.path(key, PreviewFull, hash)
+	fh, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	img, _, err := image.Decode(fh)
+	fh.Close()
+	if err != nil {
+		return fmt.Errorf("could not decode preview %s: %w", src, err)
+	}
+
+	s, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	written := s.Size()
+	for _, t := range PreviewTiers {
+		if t.Size == 0 {
+			continue
+		}
+		img = fit(img, t.Size)
+		n, err := writePreview(i.previews.path(key, t, hash), img)
+		if err != nil {
+			return err
+		}
+		written += n
+	}
+
+	if hash != "" {
+		if err := i.previews.stale(key, hash); err != nil {
+			return err
+		}
+	}
+
+	return i.previews.add(written)
+}
+
+func writePreview(output string, img image.Image) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return 0, err
+	}
+	tmp := output + ".tmp"
+	o, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	err = jpeg.Encode(o, img, &jpeg.Options{Quality: 85})
+	var n int64
+	if err == nil {
+		n, err = o.Seek(0, io.SeekCurrent)
+	}
+	o.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	return n, os.Rename(tmp, output)
+}
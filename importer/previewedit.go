@@ -0,0 +1,153 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrNotEdited is returned by GetEditedPreview for files without an edited
+// sidecar.
+var ErrNotEdited = errors.New("no edited sidecar")
+
+const editsDir = "edits"
+
+// previewEdit is a link with an edited .pho or .pp3 sidecar.
+type previewEdit struct {
+	link    string
+	key     string
+	hash    string
+	sidecar sidecar
+}
+
+// previewEdits returns the links of f with an edited sidecar, a .pho takes
+// precedence over a .pp3. Album links are skipped as they use the edits of
+// the regular links.
+func (i *Importer) previewEdits(f *File) ([]previewEdit, error) {
+	edits := make([]previewEdit, 0, 1)
+	err := i.walkLinks(f, func(link string) (bool, error) {
+		if i.isAlbumLink(link) {
+			return true, nil
+		}
+
+		var sc sidecar
+		pho, err := i.GetPho(link)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+		if err == nil && pho.Edited() {
+			sc = pho
+		}
+
+		if sc == nil {
+			pp3, err := i.GetPP3(link)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return false, err
+			}
+			if err == nil && pp3.Edited() {
+				sc = pp3
+			}
+		}
+
+		if sc == nil {
+			return true, nil
+		}
+
+		rel, err := filepath.Rel(i.colDir, link)
+		if err != nil {
+			return false, err
+		}
+		edits = append(edits, previewEdit{
+			link:    link,
+			key:     filepath.Join(editsDir, rel),
+			hash:    sidecarHash(sc, PreviewScreen.Size),
+			sidecar: sc,
+		})
+		return true, nil
+	})
+
+	return edits, err
+}
+
+// MakeEditedPreviews renders a preview with the sidecar of each edited link
+// of f of which the preview is missing or stale, i.e.: the sidecar changed
+// since. With checkOnly nothing is rendered. Reports whether any preview was
+// missing or stale.
+func (i *Importer) MakeEditedPreviews(f *File, checkOnly bool) (bool, error) {
+	edits, err := i.previewEdits(f)
+	if err != nil {
+		return false, err
+	}
+
+	var inf *info
+	stale := false
+	for _, e := range edits {
+		output := i.previews.path(e.key, PreviewFull, e.hash)
+		exists := true
+		for _, t := range PreviewTiers {
+			if _, err := os.Stat(i.previews.path(e.key, t, e.hash)); err != nil {
+				exists = false
+				break
+			}
+		}
+		if exists {
+			continue
+		}
+
+		stale = true
+		if checkOnly {
+			return stale, nil
+		}
+
+		if inf == nil {
+			m, err := GetMeta(f)
+			if err != nil {
+				return stale, err
+			}
+			// the exif date needs the creation time, the library meta
+			// itself is not embedded in previews.
+			v := metaInfo(m)
+			v.meta = nil
+			inf = &v
+		}
+
+		if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+			return stale, err
+		}
+		switch sc := e.sidecar.(type) {
+		case PP3:
+			err = i.convertPP3(e.link, output, sc, PreviewScreen.Size, *inf)
+		case Pho:
+			err = i.convertPho(e.link, output, sc, PreviewScreen.Size, *inf)
+		default:
+			err = fmt.Errorf("unsupported sidecar file of type %T", e.sidecar)
+		}
+		if err != nil {
+			return stale, err
+		}
+
+		if err := i.makeTiers(e.key, e.hash); err != nil {
+			return stale, err
+		}
+	}
+
+	return stale, nil
+}
+
+// GetEditedPreview opens the preview, in the given tier, of the first link
+// of f with an edited sidecar. ErrNotEdited is returned if there is none and
+// an error satisfying os.IsNotExist if its preview is missing or stale (see
+// MakeEditedPreviews).
+func (i *Importer) GetEditedPreview(f *File, t PreviewTier) (io.ReadCloser, error) {
+	edits, err := i.previewEdits(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(edits) == 0 {
+		return nil, ErrNotEdited
+	}
+
+	return i.previews.open(i.previews.path(edits[0].key, t, edits[0].hash))
+}
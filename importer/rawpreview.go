@@ -0,0 +1,161 @@
+package importer
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+
+	"github.com/frizinak/photos/tags"
+	"golang.org/x/image/draw"
+)
+
+// EmbeddedPreviewGen uses the largest jpeg embedded in a raw, rotated
+// according to its exif orientation and scaled down to fit Size (0: keep its
+// size).
+// ErrPreviewNotPossible is returned if the raw has no usable jpeg so the
+// next PreviewGen is tried.
+type EmbeddedPreviewGen struct {
+	Size int
+}
+
+// embeddedMinSize is the minimum width and height of an embedded jpeg,
+// smaller ones are thumbnails.
+const embeddedMinSize = 640
+
+var jpegMagic = []byte{0xff, jpegSOI, 0xff}
+
+func (e *EmbeddedPreviewGen) Name() string          { return "Embedded" }
+func (e *EmbeddedPreviewGen) Supports(f *File) bool { return f.TypeRAW() }
+
+func (e *EmbeddedPreviewGen) Make(i *Importer, f *File, output string) error {
+	fh, err := os.Open(f.Path())
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	s, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+
+	img, err := embeddedJPEG(fh, s.Size())
+	if err != nil {
+		return err
+	}
+
+	orientation := 1
+	if t, err := tags.ParseExif(f.Path()); err == nil {
+		orientation = t.Orientation()
+	}
+	img = orient(fit(img, e.Size), orientation)
+	_, err = writePreview(output, img)
+	return err
+}
+
+// embeddedJPEG scans r for jpegs and decodes the largest one. Lossless jpegs
+// (the raw data itself in some formats) are not supported by image/jpeg and
+// are skipped.
+func embeddedJPEG(r io.ReaderAt, size int64) (image.Image, error) {
+	const chunk = 1 << 20
+	buf := make([]byte, chunk+len(jpegMagic)-1)
+	var best int64 = -1
+	var bestPx int
+	for off := int64(0); off < size; off += chunk {
+		n, err := r.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		b := buf[:n]
+		for i := 0; i < n && i < chunk; {
+			j := bytes.Index(b[i:], jpegMagic)
+			if j == -1 || i+j >= chunk {
+				break
+			}
+			p := off + int64(i+j)
+			i += j + 1
+
+			c, err := jpeg.DecodeConfig(io.NewSectionReader(r, p, size-p))
+			if err != nil || c.Width < embeddedMinSize || c.Height < embeddedMinSize {
+				continue
+			}
+			if px := c.Width * c.Height; px > bestPx {
+				best, bestPx = p, px
+			}
+		}
+	}
+
+	if best == -1 {
+		return nil, ErrPreviewNotPossible
+	}
+	return jpeg.Decode(io.NewSectionReader(r, best, size-best))
+}
+
+// fit scales img down so neither side exceeds size.
+func fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	if size <= 0 || (b.Dx() <= size && b.Dy() <= size) {
+		return img
+	}
+
+	w, h := size, size
+	if b.Dx() > b.Dy() {
+		h = b.Dy() * size / b.Dx()
+	} else {
+		w = b.Dx() * size / b.Dy()
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// orient transforms img so it is displayed upright given its exif
+// orientation.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			so := src.PixOffset(src.Rect.Min.X+sx, src.Rect.Min.Y+sy)
+			do := dst.PixOffset(x, y)
+			copy(dst.Pix[do:do+4], src.Pix[so:so+4])
+		}
+	}
+
+	return dst
+}
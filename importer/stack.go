@@ -0,0 +1,149 @@
+package importer
+
+import (
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/frizinak/photos/meta"
+)
+
+// StackGap is the default maximum time between two consecutive shots of a
+// burst or bracket, the exposure time of the first is added to it.
+const StackGap = 2 * time.Second
+
+type stackShot struct {
+	f *File
+	m meta.Meta
+}
+
+// exposure returns the relative amount of light of the shot.
+func (s stackShot) exposure() float64 {
+	c := s.m.CameraInfo
+	n, iso := c.Aperture.Float(), float64(c.ISO)
+	if n == 0 {
+		n = 1
+	}
+	if iso == 0 {
+		iso = 100
+	}
+	return c.ShutterSpeed.Float() * iso / (n * n)
+}
+
+func sameExposure(a, b stackShot) bool {
+	x, y := a.exposure(), b.exposure()
+	return math.Abs(x-y) <= 1e-6*math.Max(x, y)
+}
+
+func bracket(g []stackShot) bool { return len(g) > 1 && !sameExposure(g[0], g[1]) }
+
+func stackJoins(g []stackShot, s stackShot, gap time.Duration) bool {
+	prev := g[len(g)-1]
+	if prev.m.CameraInfo == nil || s.m.CameraInfo == nil {
+		return false
+	}
+	if prev.m.CameraInfo.Device != s.m.CameraInfo.Device || prev.m.CameraInfo.Lens != s.m.CameraInfo.Lens {
+		return false
+	}
+
+	limit := gap + time.Duration(prev.m.CameraInfo.ShutterSpeed.Float()*float64(time.Second))
+	if time.Duration(s.m.Created-prev.m.Created)*time.Second > limit {
+		return false
+	}
+
+	// back at the first exposure of a bracket: the next bracket starts.
+	return !bracket(g) || !sameExposure(g[0], s)
+}
+
+// stackPick returns the index of the shot that represents g: the current
+// pick, the middle exposure of a bracket or the first shot of a burst.
+func stackPick(g []stackShot, id string) int {
+	for n, s := range g {
+		if s.m.Stack == id && s.m.Pick {
+			return n
+		}
+	}
+	if !bracket(g) {
+		return 0
+	}
+
+	ix := make([]int, len(g))
+	for n := range ix {
+		ix[n] = n
+	}
+	sort.SliceStable(ix, func(i, j int) bool { return g[ix[i]].exposure() < g[ix[j]].exposure() })
+	return ix[(len(ix)-1)/2]
+}
+
+// Stack groups files into bursts and brackets: consecutive shots with the
+// same camera and lens that are at most gap apart. The stack and pick of
+// each file is stored in its meta. Returns the amount of stacks.
+func (i *Importer) Stack(files []*File, gap time.Duration) (int, error) {
+	all := make(map[string]struct{}, len(files))
+	for _, f := range files {
+		all[f.Path()] = struct{}{}
+	}
+
+	shots := make([]stackShot, 0, len(files))
+	for _, f := range files {
+		if !f.TypeRAW() && !f.TypeImage() {
+			continue
+		}
+		m, err := i.Meta(f)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return 0, err
+		}
+		// the JPEG half of a pair follows its RAW, see SyncPair.
+		if p := PairFile(f, m); p != nil && f.TypeImage() && p.TypeRAW() {
+			if _, ok := all[p.Path()]; ok {
+				continue
+			}
+		}
+		shots = append(shots, stackShot{f, m})
+	}
+
+	sort.SliceStable(shots, func(i, j int) bool {
+		if shots[i].m.Created == shots[j].m.Created {
+			return shots[i].f.BaseFilename() < shots[j].f.BaseFilename()
+		}
+		return shots[i].m.Created < shots[j].m.Created
+	})
+
+	groups := make([][]stackShot, 0)
+	for _, s := range shots {
+		if n := len(groups); n != 0 && stackJoins(groups[n-1], s, gap) {
+			groups[n-1] = append(groups[n-1], s)
+			continue
+		}
+		groups = append(groups, []stackShot{s})
+	}
+
+	stacks := 0
+	for _, g := range groups {
+		id, pick := "", -1
+		if len(g) > 1 {
+			stacks++
+			id = g[0].f.Filename()
+			pick = stackPick(g, id)
+		}
+
+		for n, s := range g {
+			if s.m.Stack == id && s.m.Pick == (n == pick) {
+				continue
+			}
+			s.m.Stack, s.m.Pick = id, n == pick
+			if err := SaveMeta(s.f, s.m); err != nil {
+				return stacks, err
+			}
+			if _, err := SyncPair(s.f, s.m); err != nil {
+				return stacks, err
+			}
+		}
+	}
+
+	return stacks, nil
+}
@@ -0,0 +1,58 @@
+package importer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type sumIndex struct {
+	sync.Mutex
+	m map[string]*File
+	// stems maps pairKey to files, see importRun.pair.
+	stems map[string][]*File
+}
+
+func (i *Importer) loadSums() (*sumIndex, error) {
+	s := &sumIndex{m: make(map[string]*File), stems: make(map[string][]*File)}
+	err := i.All(func(f *File) (bool, error) {
+		if f.TypeRAW() || f.TypeImage() {
+			k := pairKey(f)
+			s.stems[k] = append(s.stems[k], f)
+		}
+		m, err := i.Meta(f)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return true, nil
+			}
+			return false, err
+		}
+
+		if m.Checksum != "" {
+			s.m[m.Checksum] = f
+		}
+		return true, nil
+	})
+
+	return s, err
+}
+
+// uniqueFile returns a File in dir that does not exist yet, appending -N to
+// the filename stem on collisions.
+func uniqueFile(dir string, bytes int64, fn string) (*File, error) {
+	ext := filepath.Ext(fn)
+	stem := fn[:len(fn)-len(ext)]
+	f := NewFile(dir, bytes, fn)
+	for n := 1; ; n++ {
+		_, err := os.Lstat(f.Path())
+		if os.IsNotExist(err) {
+			return f, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		f = NewFile(dir, bytes, fmt.Sprintf("%s-%d%s", stem, n, ext))
+	}
+}
@@ -0,0 +1,206 @@
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/frizinak/photos/meta"
+)
+
+const DefaultPathTemplate = "{year}/{month}-{day} {weekday}/misc/{year}-{month}-{day}-{hour}-{minute}--{filename}"
+
+var defaultPathTemplate = MustParsePathTemplate(DefaultPathTemplate)
+
+type pathVar func(f *File, m meta.Meta, arg string) string
+
+func camera(m meta.Meta, cb func(m meta.Meta) string) string {
+	if m.CameraInfo == nil {
+		return ""
+	}
+	return cb(m)
+}
+
+var pathVars = map[string]pathVar{
+	"year":    func(f *File, m meta.Meta, arg string) string { return m.CreatedTime().Format("2006") },
+	"month":   func(f *File, m meta.Meta, arg string) string { return m.CreatedTime().Format("01") },
+	"day":     func(f *File, m meta.Meta, arg string) string { return m.CreatedTime().Format("02") },
+	"hour":    func(f *File, m meta.Meta, arg string) string { return m.CreatedTime().Format("15") },
+	"minute":  func(f *File, m meta.Meta, arg string) string { return m.CreatedTime().Format("04") },
+	"second":  func(f *File, m meta.Meta, arg string) string { return m.CreatedTime().Format("05") },
+	"weekday": func(f *File, m meta.Meta, arg string) string { return m.CreatedTime().Format("Mon") },
+	"time":    func(f *File, m meta.Meta, arg string) string { return m.CreatedTime().Format(arg) },
+	"make": func(f *File, m meta.Meta, arg string) string {
+		return camera(m, func(m meta.Meta) string { return m.CameraInfo.Make })
+	},
+	"model": func(f *File, m meta.Meta, arg string) string {
+		return camera(m, func(m meta.Meta) string { return m.CameraInfo.Model })
+	},
+	"camera": func(f *File, m meta.Meta, arg string) string {
+		return camera(m, func(m meta.Meta) string { return m.CameraInfo.DeviceString() })
+	},
+	"lens": func(f *File, m meta.Meta, arg string) string {
+		return camera(m, func(m meta.Meta) string {
+			return strings.TrimSpace(m.CameraInfo.Lens.Make + " " + m.CameraInfo.Lens.Model)
+		})
+	},
+	"location": func(f *File, m meta.Meta, arg string) string {
+		if m.Location == nil {
+			return ""
+		}
+		return m.Location.Name
+	},
+	"tag": func(f *File, m meta.Meta, arg string) string {
+		if t := m.Tags.Unique(); len(t) != 0 {
+			return t[0]
+		}
+		return ""
+	},
+	"rating":   func(f *File, m meta.Meta, arg string) string { return strconv.Itoa(int(m.Rating)) },
+	"filename": func(f *File, m meta.Meta, arg string) string { return f.BaseFilename() },
+	"name": func(f *File, m meta.Meta, arg string) string {
+		fn := f.BaseFilename()
+		return strings.TrimSuffix(fn, filepath.Ext(fn))
+	},
+	"ext": func(f *File, m meta.Meta, arg string) string {
+		return strings.TrimPrefix(filepath.Ext(f.BaseFilename()), ".")
+	},
+}
+
+// PathTemplateVars lists all variables a PathTemplate can reference.
+func PathTemplateVars() []string {
+	return []string{
+		"year", "month", "day", "hour", "minute", "second", "weekday", "time:<go layout>",
+		"make", "model", "camera", "lens", "location", "tag", "rating",
+		"filename", "name", "ext",
+	}
+}
+
+type pathPart struct {
+	lit string
+	v   pathVar
+	key string
+	arg string
+	def string
+}
+
+// PathTemplate describes where links are created in the collection directory,
+// e.g.: {camera}/{year}/{location|elsewhere}/{filename}.
+//
+// A variable is written as {name}, {name:arg} or {name|default}. Empty values
+// are replaced by their default or 'unknown'. Path separators in values are
+// replaced by '-'. Use {{ and }} for literal braces.
+type PathTemplate struct {
+	raw   string
+	parts []pathPart
+}
+
+func MustParsePathTemplate(s string) *PathTemplate {
+	t, err := ParsePathTemplate(s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func ParsePathTemplate(s string) (*PathTemplate, error) {
+	t := &PathTemplate{raw: s}
+	lit := strings.Builder{}
+	unique := false
+	for n := 0; n < len(s); n++ {
+		c := s[n]
+		switch {
+		case c == '{' && n+1 < len(s) && s[n+1] == '{',
+			c == '}' && n+1 < len(s) && s[n+1] == '}':
+			lit.WriteByte(c)
+			n++
+			continue
+		case c == '}':
+			return nil, fmt.Errorf("unexpected '}' at position %d in path template", n)
+		case c != '{':
+			if c == '/' {
+				unique = false
+			}
+			lit.WriteByte(c)
+			continue
+		}
+
+		end := strings.IndexByte(s[n:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated '{' at position %d in path template", n)
+		}
+		expr := s[n+1 : n+end]
+		n += end
+
+		p := pathPart{lit: lit.String()}
+		lit.Reset()
+		if i := strings.IndexByte(expr, '|'); i != -1 {
+			expr, p.def = expr[:i], expr[i+1:]
+		}
+		if i := strings.IndexByte(expr, ':'); i != -1 {
+			expr, p.arg = expr[:i], expr[i+1:]
+		}
+		p.key = strings.TrimSpace(expr)
+		var ok bool
+		if p.v, ok = pathVars[p.key]; !ok {
+			return nil, fmt.Errorf("unknown variable '%s' in path template, expected one of: %s", p.key, strings.Join(PathTemplateVars(), ", "))
+		}
+		if p.key == "time" && p.arg == "" {
+			return nil, errors.New("{time:<layout>} requires a layout in path template")
+		}
+		if p.key == "filename" || p.key == "name" {
+			unique = true
+		}
+		t.parts = append(t.parts, p)
+	}
+	if lit.Len() != 0 {
+		t.parts = append(t.parts, pathPart{lit: lit.String()})
+	}
+
+	if !unique {
+		return nil, errors.New("the last path element of a path template should contain {filename} or {name}")
+	}
+	if strings.HasPrefix(s, "/") {
+		return nil, errors.New("path template should be relative")
+	}
+	for _, el := range strings.Split(s, "/") {
+		if el == ".." {
+			return nil, errors.New("path template should not contain '..'")
+		}
+	}
+
+	return t, nil
+}
+
+func (t *PathTemplate) String() string { return t.raw }
+
+func cleanPathValue(v string) string {
+	v = strings.TrimSpace(strings.NewReplacer("/", "-", "\\", "-", "\x00", "").Replace(v))
+	if v == "." || v == ".." {
+		return ""
+	}
+	return v
+}
+
+// Path renders the template for f in dir.
+func (t *PathTemplate) Path(dir string, f *File, m meta.Meta) string {
+	b := strings.Builder{}
+	for _, p := range t.parts {
+		b.WriteString(p.lit)
+		if p.v == nil {
+			continue
+		}
+		v := cleanPathValue(p.v(f, m, p.arg))
+		if v == "" {
+			v = cleanPathValue(p.def)
+		}
+		if v == "" {
+			v = "unknown"
+		}
+		b.WriteString(v)
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(b.String()))
+}
@@ -0,0 +1,92 @@
+package importer
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frizinak/photos/meta"
+	"github.com/frizinak/photos/tags"
+)
+
+func TestPathTemplate(t *testing.T) {
+	f := NewFile("/raws", 1234, "IMG_0001.CR2")
+	m := meta.Meta{
+		Created: time.Date(2021, 3, 4, 5, 6, 7, 0, time.Local).Unix(),
+		Rating:  4,
+		Tags:    meta.Tags{"zebra", "dog"},
+	}
+	cam := m
+	cam.CameraInfo = &tags.CameraInfo{
+		Device: tags.Device{Make: "Canon", Model: "EOS R"},
+		Lens:   tags.Device{Make: "Canon", Model: "RF35mm/1.8"},
+	}
+	cam.Location = &meta.Location{Name: "Ghent"}
+
+	tests := []struct {
+		tmpl string
+		m    meta.Meta
+		exp  string
+	}{
+		{DefaultPathTemplate, m, "2021/03-04 Thu/misc/2021-03-04-05-06--IMG_0001.CR2"},
+		{"{filename}", m, "IMG_0001.CR2"},
+		{"{name}.{ext}", m, "IMG_0001.CR2"},
+		{"{year}{month}{day}{hour}{minute}{second}/{name}", m, "20210304050607/IMG_0001"},
+		{"{time:2006-01}/{filename}", m, "2021-03/IMG_0001.CR2"},
+		{"{rating}/{tag}/{filename}", m, "4/dog/IMG_0001.CR2"},
+		{"{camera}/{filename}", m, "unknown/IMG_0001.CR2"},
+		{"{location|elsewhere}/{filename}", m, "elsewhere/IMG_0001.CR2"},
+		{"{location|elsewhere}/{filename}", cam, "Ghent/IMG_0001.CR2"},
+		{"{make}/{model}/{filename}", cam, "Canon/EOS R/IMG_0001.CR2"},
+		// path separators in values are replaced.
+		{"{lens}/{filename}", cam, "Canon RF35mm-1.8/IMG_0001.CR2"},
+		{"{{literal}}/{filename}", m, "{literal}/IMG_0001.CR2"},
+		{"{ year }/{filename}", m, "2021/IMG_0001.CR2"},
+	}
+
+	for _, test := range tests {
+		tmpl, err := ParsePathTemplate(test.tmpl)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.tmpl, err)
+			continue
+		}
+		if tmpl.String() != test.tmpl {
+			t.Errorf("%s: String() returned %s", test.tmpl, tmpl)
+		}
+		exp := filepath.Join("/col", filepath.FromSlash(test.exp))
+		if p := tmpl.Path("/col", f, test.m); p != exp {
+			t.Errorf("%s: expected %s got %s", test.tmpl, exp, p)
+		}
+	}
+}
+
+func TestPathTemplateErrors(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		err  string
+	}{
+		{"{year}/{nope}/{filename}", "unknown variable 'nope'"},
+		{"{}/{filename}", "unknown variable ''"},
+		{"{year/{filename}", "unknown variable 'year/{filename'"},
+		{"{filename", "unterminated '{' at position 0"},
+		{"year}/{filename}", "unexpected '}' at position 4"},
+		{"{time}/{filename}", "{time:<layout>} requires a layout"},
+		{"{year}", "should contain {filename} or {name}"},
+		{"{filename}/{year}", "should contain {filename} or {name}"},
+		{"/{year}/{filename}", "should be relative"},
+		{"{year}/../{filename}", "should not contain '..'"},
+		{"", "should contain {filename} or {name}"},
+	}
+
+	for _, test := range tests {
+		_, err := ParsePathTemplate(test.tmpl)
+		if err == nil {
+			t.Errorf("%s: expected error", test.tmpl)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q got %q", test.tmpl, test.err, err)
+		}
+	}
+}
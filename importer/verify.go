@@ -0,0 +1,105 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// VerifyImport resumes an interrupted import and repairs raws that have a
+// missing, unreadable or incomplete .meta file.
+func (i *Importer) VerifyImport(progress Progress) error {
+	run, pending, err := i.newImportRun(false, time.Time{})
+	if err != nil {
+		return err
+	}
+	if err := run.resume(pending); err != nil {
+		run.log.close()
+		return err
+	}
+	if err := run.log.finish(); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(i.rawDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		p := filepath.Join(i.rawDir, e.Name())
+		switch {
+		case e.IsDir() && strings.HasPrefix(e.Name(), "tmp-"):
+			i.verbose.Printf("removing stale import directory '%s'", p)
+			if err := os.RemoveAll(p); err != nil {
+				return err
+			}
+		case !e.IsDir() && strings.HasSuffix(e.Name(), ".meta.tmp"):
+			i.verbose.Printf("removing partially written '%s'", p)
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+		}
+	}
+
+	sums := make(map[string]*File)
+	err = i.AllCounted(func(f *File, n, total int) (bool, error) {
+		progress(n, total)
+		cs, err := i.verifyMeta(f)
+		if err != nil {
+			return false, err
+		}
+		if ex, ok := sums[cs]; ok {
+			i.log.Printf("%s is a duplicate of %s", f.Path(), ex.Path())
+			return true, nil
+		}
+		sums[cs] = f
+		return true, nil
+	})
+
+	return err
+}
+
+func (i *Importer) verifyMeta(f *File) (string, error) {
+	m, err := GetMeta(f)
+	if err != nil && !os.IsNotExist(err) {
+		i.log.Printf("%s: unreadable .meta (%s), recreating", f.Path(), err)
+		if err := i.remove(metaFile(f)); err != nil {
+			return "", err
+		}
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			i.log.Printf("%s: missing .meta, creating", f.Path())
+		}
+		m, err := MakeMeta(f, time.Time{})
+		return m.Checksum, err
+	}
+
+	save := false
+	if m.Checksum == "" {
+		i.log.Printf("%s: .meta has no checksum", f.Path())
+		if m.Checksum, err = sum(f.Path()); err != nil {
+			return "", err
+		}
+		save = true
+	}
+	if m.Size != f.Bytes() || m.RealFilename != f.BaseFilename() || m.BaseFilename != f.Filename() {
+		i.log.Printf("%s: .meta has an invalid size or filename", f.Path())
+		m.Size, m.RealFilename, m.BaseFilename = f.Bytes(), f.BaseFilename(), f.Filename()
+		save = true
+	}
+	if save {
+		if err := SaveMeta(f, m); err != nil {
+			return m.Checksum, err
+		}
+	}
+
+	if m.Created == 0 {
+		i.log.Printf("%s: .meta has no creation date", f.Path())
+		_, err := MakeMeta(f, time.Time{})
+		return m.Checksum, err
+	}
+
+	return m.Checksum, nil
+}
@@ -0,0 +1,130 @@
+package importer
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/frizinak/photos/meta"
+	"github.com/frizinak/photos/xmp"
+)
+
+type XMP struct {
+	path string
+	*xmp.XMP
+}
+
+func (i *Importer) xmpPath(link string) string {
+	return fmt.Sprintf("%s.xmp", link)
+}
+
+// xmpVersionOf returns the sidecar of the original if path is the sidecar of
+// a darktable duplicate, i.e.: IMG_0001_01.CR2.xmp for IMG_0001.CR2.xmp.
+func xmpVersionOf(path string) (string, bool) {
+	name := strings.TrimSuffix(path, filepath.Ext(path))
+	ext := filepath.Ext(name)
+	stem := name[:len(name)-len(ext)]
+	ix := strings.LastIndexByte(stem, '_')
+	if ix < 0 || ix == len(stem)-1 {
+		return "", false
+	}
+	for _, c := range stem[ix+1:] {
+		if c < '0' || c > '9' {
+			return "", false
+		}
+	}
+	return stem[:ix] + ext + filepath.Ext(path), true
+}
+
+func (i *Importer) GetXMP(link string) (XMP, error) {
+	xmpPath := i.xmpPath(link)
+	x, err := xmp.Load(xmpPath)
+	return XMP{xmpPath, x}, err
+}
+
+func (x XMP) Path() string { return x.path }
+func (x XMP) Save() error  { return x.SaveTo(x.path) }
+
+func sameCoord(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+
+func (i *Importer) XMPToMeta(link string) error {
+	file, err := i.fileFromLink(link)
+	if err != nil {
+		return err
+	}
+
+	m, err := EnsureMeta(file)
+	if err != nil {
+		return err
+	}
+
+	x, err := i.GetXMP(link)
+	if err != nil {
+		return err
+	}
+
+	m.Deleted = x.Rejected()
+	if !m.Deleted {
+		r := x.Rating()
+		if r > 0xff {
+			r = 0xff
+		}
+		m.Rating = uint8(r)
+	}
+	m.Tags = x.Keywords()
+	m.Label = x.Label()
+
+	if lat, lng, ok := x.GPS(); ok {
+		l := m.Location
+		if l == nil || !sameCoord(l.Lat, lat) || !sameCoord(l.Lng, lng) {
+			m.Location = &meta.Location{Lat: lat, Lng: lng}
+		}
+	}
+
+	if t, ok := x.Created(); ok && t.Unix() != m.Created {
+		m.Created = t.Unix()
+		m.CreatedOverride = true
+	}
+
+	return SaveMeta(file, m)
+}
+
+func (i *Importer) MetaToXMP(link string) error {
+	file, err := i.fileFromLink(link)
+	if err != nil {
+		return err
+	}
+
+	meta, err := EnsureMeta(file)
+	if err != nil {
+		return err
+	}
+
+	x, err := i.GetXMP(link)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		x.XMP = xmp.New()
+	}
+
+	metaToXMP(x.XMP, meta)
+
+	return x.Save()
+}
+
+func metaToXMP(x *xmp.XMP, m meta.Meta) {
+	x.SetRating(int(m.Rating))
+	if m.Deleted {
+		x.SetRating(-1)
+	}
+	x.SetKeywords(m.Tags.Unique())
+	x.SetLabel(m.Label)
+	if m.Location != nil {
+		x.SetGPS(m.Location.Lat, m.Location.Lng)
+		x.SetLocation(m.Location.Name, m.Location.Address)
+	}
+	x.SetCreated(m.CreatedTime())
+}
@@ -0,0 +1,531 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/frizinak/binary"
+)
+
+const (
+	File     = ".journal"
+	TrashDir = ".trash"
+)
+
+var version = []byte{'J', 0}
+
+// Retention bounds the journal: when a session starts the previous contents
+// of files written in older sessions are dropped once there are more than
+// KeepSessions sessions or the journal is larger than KeepSize bytes.
+// Removals are always kept, their files are in the trash until Purge.
+var (
+	KeepSessions       = 20
+	KeepSize     int64 = 512 << 20
+)
+
+type Kind uint8
+
+const (
+	KindSession Kind = iota
+	KindWrite
+	KindRemove
+	KindCreate
+)
+
+// Entry describes a single mutation.
+// For KindWrite Data holds the previous file contents (if Existed),
+// for KindRemove it holds the path the file was moved to.
+// KindCreate entries record a new file, undoing them moves it to the trash.
+type Entry struct {
+	Kind    Kind
+	Session int64
+	Time    int64
+	Path    string
+	Existed bool
+	Data    []byte
+}
+
+func (e Entry) encode(w *binary.Writer) {
+	w.WriteUint8(uint8(e.Kind))
+	w.WriteUint64(uint64(e.Session))
+	w.WriteUint64(uint64(e.Time))
+	w.WriteString(e.Path, 16)
+	var ex uint8
+	if e.Existed {
+		ex = 1
+	}
+	w.WriteUint8(ex)
+	w.WriteBytes(e.Data, 32)
+}
+
+func (e Entry) decode(r *binary.Reader) Entry {
+	e.Kind = Kind(r.ReadUint8())
+	e.Session = int64(r.ReadUint64())
+	e.Time = int64(r.ReadUint64())
+	e.Path = r.ReadString(16)
+	e.Existed = r.ReadUint8() == 1
+	e.Data = r.ReadBytes(32)
+	return e
+}
+
+type Session struct {
+	ID      int64
+	Label   string
+	Entries []Entry
+}
+
+func (s Session) Time() time.Time { return time.Unix(0, s.ID) }
+
+var (
+	sem     sync.Mutex
+	session = time.Now().UnixNano()
+	label   string
+	started = map[string]struct{}{}
+)
+
+// SetLabel sets the description of the current session, e.g.: the actions
+// being run.
+func SetLabel(l string) {
+	sem.Lock()
+	label = l
+	sem.Unlock()
+}
+
+func path(dir string) string { return filepath.Join(dir, File) }
+
+func record(dir string, e Entry) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	sem.Lock()
+	defer sem.Unlock()
+	buf := bytes.NewBuffer(nil)
+	if _, ok := started[dir]; !ok {
+		if err := compact(dir); err != nil {
+			return err
+		}
+		frame(buf, Entry{Kind: KindSession, Session: session, Time: session, Path: label})
+	}
+
+	e.Session = session
+	e.Time = time.Now().UnixNano()
+	frame(buf, e)
+
+	p := path(dir)
+	_, err = os.Stat(p)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	nw := err != nil
+
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if nw {
+		f.Write(version)
+	}
+
+	_, err = f.Write(buf.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		started[dir] = struct{}{}
+	}
+	return err
+}
+
+func frame(buf *bytes.Buffer, e Entry) {
+	b := bytes.NewBuffer(nil)
+	e.encode(binary.NewWriter(b))
+	binary.NewWriter(buf).WriteUint32(uint32(b.Len()))
+	buf.Write(b.Bytes())
+}
+
+// Write records the current contents of file before it is overwritten.
+// The entry is stored in the journal of the directory containing file.
+func Write(file string) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+
+	return WriteIn(filepath.Dir(abs), abs)
+}
+
+// WriteIn is Write but stores the entry in the journal of dir.
+func WriteIn(dir, file string) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+
+	d, err := os.ReadFile(abs)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return record(dir, Entry{Kind: KindWrite, Path: abs, Existed: err == nil, Data: d})
+}
+
+// Create records that file was created, undoing it moves file to the trash.
+func Create(dir, file string) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+
+	return record(dir, Entry{Kind: KindCreate, Path: abs})
+}
+
+// Remove moves file to the trash directory of the journal in dir.
+func Remove(dir, file string) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+
+	dest, err := trash(dir, session, abs)
+	if err != nil {
+		return err
+	}
+
+	return record(dir, Entry{Kind: KindRemove, Path: abs, Data: []byte(dest)})
+}
+
+// trash moves file to the trash directory of session and returns its new
+// path.
+func trash(dir string, session int64, file string) (string, error) {
+	tdir := filepath.Join(dir, TrashDir, strconv.FormatInt(session, 10))
+	if err := os.MkdirAll(tdir, 0755); err != nil {
+		return "", err
+	}
+
+	f, err := os.CreateTemp(tdir, "*-"+filepath.Base(file))
+	if err != nil {
+		return "", err
+	}
+	dest := f.Name()
+	f.Close()
+
+	if err := move(file, dest); err != nil {
+		os.Remove(dest)
+		return "", err
+	}
+	return dest, nil
+}
+
+func move(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	st, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	if st.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		os.Remove(dst)
+		if err := os.Symlink(target, dst); err != nil {
+			return err
+		}
+		return os.Remove(src)
+	}
+
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	d, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(d, s)
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+func read(dir string) ([]Entry, error) {
+	f, err := os.Open(path(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	v := make([]byte, len(version))
+	if _, err := io.ReadFull(r, v); err != nil {
+		return nil, fmt.Errorf("invalid journal '%s': %w", path(dir), err)
+	}
+	if !bytes.Equal(v, version) {
+		return nil, fmt.Errorf("journal version mismatch: %+v != expected %+v in '%s'", v, version, path(dir))
+	}
+
+	list := make([]Entry, 0)
+	br := binary.NewReader(r)
+	for {
+		n := br.ReadUint32()
+		if err := br.Err(); err != nil {
+			if err == io.EOF {
+				return list, nil
+			}
+			return list, fmt.Errorf("corrupt journal '%s': %w", path(dir), err)
+		}
+
+		d := make([]byte, n)
+		if _, err := io.ReadFull(r, d); err != nil {
+			return list, fmt.Errorf("corrupt journal '%s': %w", path(dir), err)
+		}
+
+		er := binary.NewReader(bytes.NewReader(d))
+		e := Entry{}.decode(er)
+		if err := er.Err(); err != nil {
+			return list, fmt.Errorf("corrupt journal '%s': %w", path(dir), err)
+		}
+		list = append(list, e)
+	}
+}
+
+// Sessions returns all sessions in the journal of dir, oldest first.
+func Sessions(dir string) ([]Session, error) {
+	list, err := read(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	m := make(map[int64]*Session)
+	for _, e := range list {
+		s, ok := m[e.Session]
+		if !ok {
+			s = &Session{ID: e.Session}
+			m[e.Session] = s
+		}
+		if e.Kind == KindSession {
+			s.Label = e.Path
+			continue
+		}
+		s.Entries = append(s.Entries, e)
+	}
+
+	sessions := make([]Session, 0, len(m))
+	for _, s := range m {
+		sessions = append(sessions, *s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+
+	return sessions, nil
+}
+
+// Undo reverts all sessions with an ID >= from, newest change first, and
+// removes them from the journal. The restored paths are returned.
+func Undo(dir string, from int64) ([]string, error) {
+	sem.Lock()
+	defer sem.Unlock()
+
+	list, err := read(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := make([]Entry, 0, len(list))
+	undo := make([]Entry, 0)
+	for _, e := range list {
+		if e.Session >= from {
+			undo = append(undo, e)
+			continue
+		}
+		keep = append(keep, e)
+	}
+
+	paths := make([]string, 0, len(undo))
+	var gerr error
+	for n := len(undo) - 1; n >= 0; n-- {
+		e := undo[n]
+		var err error
+		switch e.Kind {
+		case KindWrite:
+			err = restore(e)
+		case KindRemove:
+			err = os.MkdirAll(filepath.Dir(e.Path), 0755)
+			if err == nil {
+				err = move(string(e.Data), e.Path)
+			}
+		case KindCreate:
+			_, err = trash(dir, e.Session, e.Path)
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		default:
+			continue
+		}
+
+		if err != nil {
+			// keep what we could not undo
+			keep = append(keep, undo[:n+1]...)
+			gerr = fmt.Errorf("could not undo change to '%s': %w", e.Path, err)
+			break
+		}
+		paths = append(paths, e.Path)
+	}
+
+	if err := rewrite(dir, keep); err != nil {
+		return paths, err
+	}
+
+	if gerr == nil {
+		for _, e := range undo {
+			if e.Kind == KindSession {
+				os.Remove(filepath.Join(dir, TrashDir, strconv.FormatInt(e.Session, 10)))
+			}
+		}
+	}
+
+	return paths, gerr
+}
+
+func restore(e Entry) error {
+	if !e.Existed {
+		err := os.Remove(e.Path)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+
+	tmp := e.Path + ".tmp"
+	if err := os.WriteFile(tmp, e.Data, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, e.Path)
+}
+
+// Purge empties the trash and the journal in dir, changes made before this
+// call can no longer be undone.
+func Purge(dir string) error {
+	sem.Lock()
+	defer sem.Unlock()
+	if err := os.RemoveAll(filepath.Join(dir, TrashDir)); err != nil {
+		return err
+	}
+	err := os.Remove(path(dir))
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	started = map[string]struct{}{}
+	return err
+}
+
+// compact drops the previous contents of written files of the oldest
+// sessions, see KeepSessions and KeepSize.
+func compact(dir string) error {
+	st, err := os.Stat(path(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	list, err := read(dir)
+	if err != nil {
+		return err
+	}
+
+	// data is the size of the previous contents written in each session,
+	// other the amount of entries that are not writes.
+	ids := make([]int64, 0)
+	data := make(map[int64]int64)
+	other := make(map[int64]int)
+	for _, e := range list {
+		if _, ok := data[e.Session]; !ok {
+			ids = append(ids, e.Session)
+			data[e.Session] = 0
+		}
+		switch e.Kind {
+		case KindWrite:
+			data[e.Session] += int64(len(e.Data))
+		case KindSession:
+		default:
+			other[e.Session]++
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	// keep at most KeepSessions-1 old sessions, the session that is about
+	// to start is the last one.
+	size, drop := st.Size(), make(map[int64]struct{})
+	for n, id := range ids {
+		if len(ids)-n < KeepSessions && size <= KeepSize {
+			break
+		}
+		drop[id] = struct{}{}
+		size -= data[id]
+	}
+	if len(drop) == 0 {
+		return nil
+	}
+
+	keep := list[:0]
+	for _, e := range list {
+		if _, ok := drop[e.Session]; ok {
+			if e.Kind == KindWrite || (e.Kind == KindSession && other[e.Session] == 0) {
+				continue
+			}
+		}
+		keep = append(keep, e)
+	}
+
+	return rewrite(dir, keep)
+}
+
+func rewrite(dir string, list []Entry) error {
+	p := path(dir)
+	tmp := p + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	buf.Write(version)
+	for _, e := range list {
+		frame(buf, e)
+	}
+
+	_, err = f.Write(buf.Bytes())
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, p)
+}
@@ -0,0 +1,39 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestCompactKeepSessions(t *testing.T) {
+	keep, sess, st := KeepSessions, session, started
+	defer func() { KeepSessions, session, started = keep, sess, st }()
+	KeepSessions = 3
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	for n := 1; n <= 5; n++ {
+		session = int64(n)
+		started = map[string]struct{}{}
+		if err := WriteIn(dir, file); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(strconv.Itoa(n)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sessions, err := Sessions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int64, len(sessions))
+	for i, s := range sessions {
+		ids[i] = s.ID
+	}
+	if len(ids) != KeepSessions || ids[0] != 3 {
+		t.Errorf("expected sessions 3 through 5 got %v", ids)
+	}
+}
@@ -0,0 +1,222 @@
+package meta
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/frizinak/binary"
+)
+
+const IndexFile = ".meta-index"
+
+var indexVersion = []byte{'I', 0}
+
+var (
+	indexLock sync.Mutex
+	indexes   = map[string]*Index{}
+)
+
+// Index holds all meta records of a directory in a single append-only file,
+// Meta.Save appends a record on every write.
+type Index struct {
+	path    string
+	sem     sync.RWMutex
+	m       map[string]Meta
+	records int
+}
+
+func indexPath(dir string) string { return filepath.Join(dir, IndexFile) }
+
+func NewIndex(dir string) *Index {
+	return &Index{path: indexPath(dir), m: make(map[string]Meta)}
+}
+
+// OpenIndex loads the index of dir. An error satisfying os.IsNotExist is
+// returned if it has not been built yet.
+func OpenIndex(dir string) (*Index, error) {
+	dir = filepath.Clean(dir)
+	indexLock.Lock()
+	defer indexLock.Unlock()
+	if idx, ok := indexes[dir]; ok {
+		return idx, nil
+	}
+
+	idx := NewIndex(dir)
+	f, err := os.Open(idx.path)
+	if err != nil {
+		return nil, err
+	}
+	torn, err := idx.read(bufio.NewReader(f))
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if torn || idx.records > 2*len(idx.m)+100 {
+		if err := idx.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	indexes[dir] = idx
+	return idx, nil
+}
+
+func (idx *Index) read(r io.Reader) (torn bool, err error) {
+	version := make([]byte, len(indexVersion))
+	if _, err := io.ReadFull(r, version); err != nil {
+		return false, fmt.Errorf("invalid index file '%s': %w", idx.path, err)
+	}
+	if !bytes.Equal(version, indexVersion) {
+		return false, fmt.Errorf("index version mismatch: %+v != expected %+v in '%s'", version, indexVersion, idx.path)
+	}
+
+	br := binary.NewReader(r)
+	for {
+		n := br.ReadUint32()
+		if err := br.Err(); err != nil {
+			return err != io.EOF, nil
+		}
+
+		rec := make([]byte, n)
+		if _, err := io.ReadFull(r, rec); err != nil {
+			return true, nil
+		}
+
+		rr := binary.NewReader(bytes.NewReader(rec))
+		key := rr.ReadString(16)
+		d := rr.ReadBytes(32)
+		if err := rr.Err(); err != nil {
+			return true, nil
+		}
+
+		m, err := decode(d, idx.path)
+		if err != nil {
+			return false, err
+		}
+
+		idx.m[key] = m
+		idx.records++
+	}
+}
+
+func record(key string, d []byte) []byte {
+	buf := bytes.NewBuffer(nil)
+	w := binary.NewWriter(buf)
+	w.WriteString(key, 16)
+	w.WriteBytes(d, 32)
+
+	rec := bytes.NewBuffer(make([]byte, 0, buf.Len()+4))
+	binary.NewWriter(rec).WriteUint32(uint32(buf.Len()))
+	rec.Write(buf.Bytes())
+	return rec.Bytes()
+}
+
+func (idx *Index) Get(key string) (Meta, bool) {
+	idx.sem.RLock()
+	m, ok := idx.m[key]
+	idx.sem.RUnlock()
+	return m.clone(), ok
+}
+
+// Set stores a copy of m, later changes to its maps and slices by the caller
+// do not leak into the index.
+func (idx *Index) Set(key string, m Meta) {
+	m = m.clone()
+	idx.sem.Lock()
+	idx.m[key] = m
+	idx.sem.Unlock()
+}
+
+func (idx *Index) Add(key string, m Meta) error {
+	d, err := m.bytes()
+	if err != nil {
+		return err
+	}
+	idx.Set(key, m)
+	return appendIndex(idx.path, key, d)
+}
+
+func (idx *Index) Save() error {
+	indexLock.Lock()
+	defer indexLock.Unlock()
+	if err := idx.save(); err != nil {
+		return err
+	}
+	indexes[filepath.Dir(idx.path)] = idx
+	return nil
+}
+
+func (idx *Index) save() error {
+	appendLock.Lock()
+	defer appendLock.Unlock()
+	idx.sem.RLock()
+	defer idx.sem.RUnlock()
+
+	tmp := idx.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	w.Write(indexVersion)
+	for k, m := range idx.m {
+		d, err := m.bytes()
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+		w.Write(record(k, d))
+	}
+
+	err = w.Flush()
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	idx.records = len(idx.m)
+	return os.Rename(tmp, idx.path)
+}
+
+var appendLock sync.Mutex
+
+func appendIndex(path, key string, d []byte) error {
+	appendLock.Lock()
+	defer appendLock.Unlock()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	_, err = f.Write(record(key, d))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func indexUpdate(path string, m Meta, d []byte) error {
+	dir, key := filepath.Split(path)
+	dir = filepath.Clean(dir)
+	indexLock.Lock()
+	idx := indexes[dir]
+	indexLock.Unlock()
+	if idx != nil {
+		idx.Set(key, m)
+	}
+
+	return appendIndex(indexPath(dir), key, d)
+}
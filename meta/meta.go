@@ -10,13 +10,19 @@ import (
 	"time"
 
 	"github.com/frizinak/binary"
+	"github.com/frizinak/photos/journal"
 	"github.com/frizinak/photos/tags"
 	jsoniter "github.com/json-iterator/go"
 )
 
 var (
 	metaVersion0   = []byte{'M', 0}
-	metaVersion    = []byte{'M', 1}
+	metaVersion1   = []byte{'M', 1}
+	metaVersion2   = []byte{'M', 2}
+	metaVersion3   = []byte{'M', 3}
+	metaVersion4   = []byte{'M', 4}
+	metaVersion5   = []byte{'M', 5}
+	metaVersion    = []byte{'M', 6}
 	oldJSONVersion = []byte{'{', '"'}
 )
 
@@ -124,6 +130,22 @@ type Meta struct {
 	Location *Location
 
 	CameraInfo *tags.CameraInfo
+
+	// Pair is the filename of the other half of a RAW+JPEG capture.
+	Pair string
+
+	// Stack identifies the burst or bracket this file is part of, Pick is
+	// set on the file that represents it.
+	Stack string
+	Pick  bool
+
+	// PHash is the perceptual hash of the preview if PHashed is set, 0 is
+	// a valid hash (e.g.: of a black frame).
+	PHash   uint64
+	PHashed bool
+
+	// Label is the (color) label of xmp sidecars, e.g.: Red.
+	Label string
 }
 
 func (m Meta) decode0(r *binary.Reader) Meta {
@@ -155,12 +177,44 @@ func (m Meta) decode0(r *binary.Reader) Meta {
 	return m
 }
 
-func (m Meta) decode(r *binary.Reader) Meta {
+func (m Meta) decode1(r *binary.Reader) Meta {
 	m = m.decode0(r)
 	m.CreatedOverride = r.ReadUint8() == 1
 	return m
 }
 
+func (m Meta) decode2(r *binary.Reader) Meta {
+	m = m.decode1(r)
+	m.Pair = r.ReadString(16)
+	return m
+}
+
+func (m Meta) decode3(r *binary.Reader) Meta {
+	m = m.decode2(r)
+	m.Stack = r.ReadString(16)
+	m.Pick = r.ReadUint8() == 1
+	return m
+}
+
+func (m Meta) decode4(r *binary.Reader) Meta {
+	m = m.decode3(r)
+	m.PHash = r.ReadUint64()
+	m.PHashed = m.PHash != 0
+	return m
+}
+
+func (m Meta) decode5(r *binary.Reader) Meta {
+	m = m.decode4(r)
+	m.Label = r.ReadString(16)
+	return m
+}
+
+func (m Meta) decode(r *binary.Reader) Meta {
+	m = m.decode5(r)
+	m.PHashed = r.ReadUint8() == 1
+	return m
+}
+
 func (m Meta) encode(w *binary.Writer) {
 	w.WriteString(m.Checksum, 16)
 	w.WriteUint32(uint32(m.Size))
@@ -215,6 +269,25 @@ func (m Meta) encode(w *binary.Writer) {
 		d = 1
 	}
 	w.WriteUint8(d)
+
+	w.WriteString(m.Pair, 16)
+
+	w.WriteString(m.Stack, 16)
+	var pick uint8
+	if m.Pick {
+		pick = 1
+	}
+	w.WriteUint8(pick)
+
+	w.WriteUint64(m.PHash)
+
+	w.WriteString(m.Label, 16)
+
+	var phashed uint8
+	if m.PHashed {
+		phashed = 1
+	}
+	w.WriteUint8(phashed)
 }
 
 func New(size int64, real string, base string) Meta {
@@ -225,6 +298,28 @@ func New(size int64, real string, base string) Meta {
 	return m
 }
 
+func (m Meta) clone() Meta {
+	if m.Conv != nil {
+		conv := make(map[string]Converted, len(m.Conv))
+		for k, v := range m.Conv {
+			conv[k] = v
+		}
+		m.Conv = conv
+	}
+	if m.Tags != nil {
+		m.Tags = append(make(Tags, 0, len(m.Tags)), m.Tags...)
+	}
+	if m.Location != nil {
+		loc := *m.Location
+		m.Location = &loc
+	}
+	if m.CameraInfo != nil {
+		ci := *m.CameraInfo
+		m.CameraInfo = &ci
+	}
+	return m
+}
+
 func (m Meta) CreatedTime() time.Time {
 	return time.Unix(m.Created, 0)
 }
@@ -241,13 +336,17 @@ func loadOld(r io.Reader) (Meta, error) {
 }
 
 func Load(path string) (Meta, error) {
-	var m Meta
 	// files should be tiny. so just alloc once.
 	d, err := os.ReadFile(path)
 	if err != nil {
-		return m, err
+		return Meta{}, err
 	}
 
+	return decode(d, path)
+}
+
+func decode(d []byte, path string) (Meta, error) {
+	var m Meta
 	if len(d) < len(metaVersion) {
 		return m, fmt.Errorf("invalid meta file '%s'", path)
 	}
@@ -263,6 +362,21 @@ func Load(path string) (Meta, error) {
 	if bytes.Equal(version, metaVersion) {
 		decoder = m.decode
 	}
+	if bytes.Equal(version, metaVersion5) {
+		decoder = m.decode5
+	}
+	if bytes.Equal(version, metaVersion4) {
+		decoder = m.decode4
+	}
+	if bytes.Equal(version, metaVersion3) {
+		decoder = m.decode3
+	}
+	if bytes.Equal(version, metaVersion2) {
+		decoder = m.decode2
+	}
+	if bytes.Equal(version, metaVersion1) {
+		decoder = m.decode1
+	}
 	if bytes.Equal(version, metaVersion0) {
 		decoder = m.decode0
 	}
@@ -274,34 +388,48 @@ func Load(path string) (Meta, error) {
 	r := binary.NewReader(buf)
 	m = decoder(r)
 
+	var err error
 	if err = r.Err(); err != nil {
 		err = fmt.Errorf("could not load meta %s: %w", path, err)
 	}
 	return m, err
 }
 
+func (m Meta) bytes() ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(metaVersion)
+	w := binary.NewWriter(buf)
+	m.encode(w)
+	return buf.Bytes(), w.Err()
+}
+
 func (m Meta) Save(path string) error {
+	m.Tags = m.Tags.Unique()
+	d, err := m.bytes()
+	if err != nil {
+		return err
+	}
+
+	if err := journal.Write(path); err != nil {
+		return err
+	}
+
 	tmp := path + ".tmp"
 	f, err := os.Create(tmp)
 	if err != nil {
 		return err
 	}
 
-	m.Tags = m.Tags.Unique()
-	_, err = f.Write(metaVersion)
+	_, err = f.Write(d)
+	f.Close()
 	if err != nil {
-		f.Close()
 		os.Remove(tmp)
 		return err
 	}
 
-	w := binary.NewWriter(f)
-	m.encode(w)
-	f.Close()
-	if err := w.Err(); err != nil {
-		os.Remove(tmp)
+	if err := os.Rename(tmp, path); err != nil {
 		return err
 	}
 
-	return os.Rename(tmp, path)
+	return indexUpdate(path, m, d)
 }
@@ -0,0 +1,359 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Op is the comparison operator of a predicate.
+type Op string
+
+const (
+	OpNone  Op = ""
+	OpMatch Op = ":"
+	OpEQ    Op = "="
+	OpNE    Op = "!="
+	OpGT    Op = ">"
+	OpGE    Op = ">="
+	OpLT    Op = "<"
+	OpLE    Op = "<="
+)
+
+// ops ordered so that two character operators are tried first.
+var ops = []Op{OpGE, OpLE, OpNE, OpMatch, OpEQ, OpGT, OpLT}
+
+type Node interface {
+	String() string
+}
+
+type And struct{ L, R Node }
+type Or struct{ L, R Node }
+type Not struct{ N Node }
+
+// Pred is a single predicate, e.g.: rating>=3, tag:dog or deleted.
+type Pred struct {
+	Field string
+	Op    Op
+	Value string
+}
+
+// Ref references a saved query by name, e.g.: @keepers.
+type Ref struct{ Name string }
+
+func (n And) String() string { return fmt.Sprintf("(%s and %s)", n.L, n.R) }
+func (n Or) String() string  { return fmt.Sprintf("(%s or %s)", n.L, n.R) }
+func (n Not) String() string { return fmt.Sprintf("not %s", n.N) }
+func (n Ref) String() string { return "@" + n.Name }
+
+func (n Pred) String() string {
+	if n.Op == OpNone {
+		return n.Field
+	}
+	v := n.Value
+	if v == "" || strings.ContainsAny(v, " \t\"()") {
+		v = `"` + strings.ReplaceAll(v, `"`, `\"`) + `"`
+	}
+	return n.Field + string(n.Op) + v
+}
+
+// Walk calls cb for n and all its descendants, stopping at the first error.
+func Walk(n Node, cb func(Node) error) error {
+	if err := cb(n); err != nil {
+		return err
+	}
+	switch n := n.(type) {
+	case And:
+		if err := Walk(n.L, cb); err != nil {
+			return err
+		}
+		return Walk(n.R, cb)
+	case Or:
+		if err := Walk(n.L, cb); err != nil {
+			return err
+		}
+		return Walk(n.R, cb)
+	case Not:
+		return Walk(n.N, cb)
+	}
+	return nil
+}
+
+// Resolve replaces all references using lookup.
+func Resolve(n Node, lookup func(name string) (Node, error)) (Node, error) {
+	return resolve(n, lookup, map[string]struct{}{})
+}
+
+func resolve(n Node, lookup func(string) (Node, error), seen map[string]struct{}) (Node, error) {
+	var err error
+	switch v := n.(type) {
+	case And:
+		if v.L, err = resolve(v.L, lookup, seen); err != nil {
+			return nil, err
+		}
+		v.R, err = resolve(v.R, lookup, seen)
+		return v, err
+	case Or:
+		if v.L, err = resolve(v.L, lookup, seen); err != nil {
+			return nil, err
+		}
+		v.R, err = resolve(v.R, lookup, seen)
+		return v, err
+	case Not:
+		v.N, err = resolve(v.N, lookup, seen)
+		return v, err
+	case Ref:
+		if _, ok := seen[v.Name]; ok {
+			return nil, fmt.Errorf("query @%s references itself", v.Name)
+		}
+		r, err := lookup(v.Name)
+		if err != nil {
+			return nil, err
+		}
+		seen[v.Name] = struct{}{}
+		r, err = resolve(r, lookup, seen)
+		delete(seen, v.Name)
+		return r, err
+	}
+	return n, nil
+}
+
+type tokenType byte
+
+const (
+	tokEOF tokenType = iota
+	tokWord
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+)
+
+type token struct {
+	typ tokenType
+	val string
+	pos int
+}
+
+func lex(q string) ([]token, error) {
+	toks := make([]token, 0)
+	r := []rune(q)
+	for i := 0; i < len(r); {
+		c := r[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c == '(':
+			toks = append(toks, token{tokLParen, "(", i})
+			i++
+			continue
+		case c == ')':
+			toks = append(toks, token{tokRParen, ")", i})
+			i++
+			continue
+		case c == '!' && (i+1 >= len(r) || r[i+1] != '='):
+			toks = append(toks, token{tokNot, "!", i})
+			i++
+			continue
+		}
+
+		start := i
+		quoted := false
+		word := strings.Builder{}
+		for i < len(r) {
+			c := r[i]
+			if c == '"' {
+				quoted = true
+				i++
+				closed := false
+				for i < len(r) {
+					if r[i] == '\\' && i+1 < len(r) {
+						word.WriteRune(r[i+1])
+						i += 2
+						continue
+					}
+					if r[i] == '"' {
+						closed = true
+						i++
+						break
+					}
+					word.WriteRune(r[i])
+					i++
+				}
+				if !closed {
+					return nil, fmt.Errorf("unterminated string at position %d", start)
+				}
+				continue
+			}
+			if unicode.IsSpace(c) || c == '(' || c == ')' {
+				break
+			}
+			word.WriteRune(c)
+			i++
+		}
+
+		w := word.String()
+		typ := tokWord
+		if !quoted {
+			switch strings.ToLower(w) {
+			case "and", "&&", "&":
+				typ = tokAnd
+			case "or", "||", "|":
+				typ = tokOr
+			case "not":
+				typ = tokNot
+			}
+		}
+		toks = append(toks, token{typ, w, start})
+	}
+
+	return append(toks, token{tokEOF, "", len(r)}), nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// Parse parses a query.
+//
+//	query     = or
+//	or        = and { ("or" | "||") and }
+//	and       = unary { [ "and" | "&&" ] unary }
+//	unary     = ( "not" | "!" ) unary | "(" or ")" | predicate
+//	predicate = "@" name | field [ op value ]
+//	op        = ":" | "=" | "!=" | ">" | ">=" | "<" | "<="
+//
+// Juxtaposed terms are and'ed, values containing spaces can be quoted:
+//
+//	rating>=3 or (tag:dog and lens:*35mm*)
+//	not deleted tag:"new york"
+func Parse(q string) (Node, error) {
+	toks, err := lex(q)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	if p.peek().typ == tokEOF {
+		return nil, errors.New("empty query")
+	}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokEOF {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", t.val, t.pos)
+	}
+	return n, nil
+}
+
+func (p *parser) or() (Node, error) {
+	n, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().typ == tokOr {
+		p.next()
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		n = Or{n, r}
+	}
+	return n, nil
+}
+
+func (p *parser) and() (Node, error) {
+	n, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek().typ {
+		case tokAnd:
+			p.next()
+		case tokWord, tokNot, tokLParen:
+		default:
+			return n, nil
+		}
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		n = And{n, r}
+	}
+}
+
+func (p *parser) unary() (Node, error) {
+	t := p.next()
+	switch t.typ {
+	case tokNot:
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{n}, nil
+	case tokLParen:
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.typ != tokRParen {
+			return nil, fmt.Errorf("expected ')' at position %d", c.pos)
+		}
+		return n, nil
+	case tokWord:
+		return predicate(t)
+	case tokEOF:
+		return nil, errors.New("unexpected end of query")
+	}
+	return nil, fmt.Errorf("unexpected '%s' at position %d", t.val, t.pos)
+}
+
+func predicate(t token) (Node, error) {
+	w := t.val
+	if strings.HasPrefix(w, "@") {
+		if len(w) == 1 {
+			return nil, fmt.Errorf("missing query name at position %d", t.pos)
+		}
+		return Ref{w[1:]}, nil
+	}
+
+	ix, op := -1, OpNone
+	for i := range w {
+		for _, o := range ops {
+			if strings.HasPrefix(w[i:], string(o)) {
+				ix, op = i, o
+				break
+			}
+		}
+		if ix != -1 {
+			break
+		}
+	}
+
+	if ix == -1 {
+		return Pred{Field: strings.ToLower(w)}, nil
+	}
+	if ix == 0 {
+		return nil, fmt.Errorf("missing field name in '%s' at position %d", w, t.pos)
+	}
+
+	return Pred{
+		Field: strings.ToLower(w[:ix]),
+		Op:    op,
+		Value: w[ix+len(op):],
+	}, nil
+}
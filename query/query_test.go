@@ -0,0 +1,157 @@
+package query
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		q   string
+		exp string
+	}{
+		{"deleted", "deleted"},
+		{"Rating>=3", "rating>=3"},
+		{"rating>=3 tag:dog", "(rating>=3 and tag:dog)"},
+		{"rating>=3 and tag:dog", "(rating>=3 and tag:dog)"},
+		{"rating>=3 && tag:dog", "(rating>=3 and tag:dog)"},
+		{"a or b and c", "(a or (b and c))"},
+		{"a || b | c", "((a or b) or c)"},
+		{"(a or b) c", "((a or b) and c)"},
+		{"not deleted", "not deleted"},
+		{"!deleted", "not deleted"},
+		{"! ! deleted", "not not deleted"},
+		{"rating!=0", "rating!=0"},
+		{"rating<2 or rating>4", "(rating<2 or rating>4)"},
+		{"rating<=2", "rating<=2"},
+		{"camera=x", "camera=x"},
+		{`tag:"new york"`, `tag:"new york"`},
+		{`tag:"say \"hi\""`, `tag:"say \"hi\""`},
+		{`tag:""`, `tag:""`},
+		{`"and"`, "and"},
+		{"@keepers rating>3", "(@keepers and rating>3)"},
+		{"lens:*35mm* or (tag:dog and not tag:cat)", "(lens:*35mm* or (tag:dog and not tag:cat))"},
+	}
+
+	for _, test := range tests {
+		n, err := Parse(test.q)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.q, err)
+			continue
+		}
+		if s := n.String(); s != test.exp {
+			t.Errorf("%q: expected %s got %s", test.q, test.exp, s)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		q   string
+		err string
+	}{
+		{"", "empty query"},
+		{"   ", "empty query"},
+		{"(a or b", "expected ')' at position 7"},
+		{"((a)", "expected ')' at position 4"},
+		{"a)", "unexpected ')' at position 1"},
+		{"()", "unexpected ')' at position 1"},
+		{"a or", "unexpected end of query"},
+		{"a and", "unexpected end of query"},
+		{"not", "unexpected end of query"},
+		{"or a", "unexpected 'or' at position 0"},
+		{"AND", "unexpected 'AND' at position 0"},
+		{"a and or b", "unexpected 'or' at position 6"},
+		{`tag:"dog`, "unterminated string at position 0"},
+		{"@", "missing query name at position 0"},
+		{">=3", "missing field name in '>=3' at position 0"},
+		{"a :dog", "missing field name in ':dog' at position 2"},
+	}
+
+	for _, test := range tests {
+		n, err := Parse(test.q)
+		if err == nil {
+			t.Errorf("%q: expected error, got %s", test.q, n)
+			continue
+		}
+		if err.Error() != test.err {
+			t.Errorf("%q: expected error %q got %q", test.q, test.err, err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	saved := map[string]string{
+		"keepers": "rating>=3 not deleted",
+		"dogs":    "tag:dog @keepers",
+		"self":    "a @self",
+		"loop1":   "@loop2",
+		"loop2":   "b or @loop1",
+	}
+	lookup := func(name string) (Node, error) {
+		q, ok := saved[name]
+		if !ok {
+			return nil, fmt.Errorf("no such query @%s", name)
+		}
+		return Parse(q)
+	}
+
+	tests := []struct {
+		q   string
+		exp string
+		err string
+	}{
+		{q: "@keepers", exp: "(rating>=3 and not deleted)"},
+		{q: "@dogs or @keepers", exp: "((tag:dog and (rating>=3 and not deleted)) or (rating>=3 and not deleted))"},
+		{q: "not @keepers", exp: "not (rating>=3 and not deleted)"},
+		{q: "@missing", err: "no such query @missing"},
+		{q: "@self", err: "query @self references itself"},
+		{q: "@loop1", err: "query @loop1 references itself"},
+	}
+
+	for _, test := range tests {
+		n, err := Parse(test.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err = Resolve(n, lookup)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%q: expected error %q got %v", test.q, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.q, err)
+			continue
+		}
+		if s := n.String(); s != test.exp {
+			t.Errorf("%q: expected %s got %s", test.q, test.exp, s)
+		}
+		Walk(n, func(n Node) error {
+			if _, ok := n.(Ref); ok {
+				t.Errorf("%q: unresolved reference %s", test.q, n)
+			}
+			return nil
+		})
+	}
+}
+
+func TestPredString(t *testing.T) {
+	// String output parses back to the same predicate.
+	for _, p := range []Pred{
+		{Field: "deleted"},
+		{Field: "tag", Op: OpMatch, Value: "new york"},
+		{Field: "tag", Op: OpMatch, Value: `a "b" (c)`},
+		{Field: "tag", Op: OpEQ, Value: ""},
+	} {
+		n, err := Parse(p.String())
+		if err != nil {
+			t.Errorf("%s: %s", p, err)
+			continue
+		}
+		if n != p {
+			t.Errorf("%s: expected %#v got %#v", p, p, n)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+//go:build !nogl
+
+package rate
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/frizinak/photos/importer"
+	"github.com/frizinak/photos/meta"
+	"github.com/go-gl/gl/v4.6-core/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+const (
+	compareMaxZoom = 16
+	compareBorder  = 2
+)
+
+type compareState struct {
+	enabled bool
+	files   []int
+	// zoom is relative to the image fitting its pane, cx and cy are the
+	// center of the view in image coordinates (0-1), shared by all panes.
+	zoom   float64
+	cx, cy float64
+	// actual requests zooming to 1:1 of the current image once its size
+	// is known.
+	actual bool
+
+	drag         bool
+	dragX, dragY float64
+}
+
+// toggleCompare compares the selection (if 2-4 files are selected in the
+// grid) or the current and next image.
+func (r *Rater) toggleCompare() {
+	r.compare.enabled = !r.compare.enabled
+	if !r.compare.enabled {
+		return
+	}
+
+	if r.grid.enabled && len(r.grid.selected) > 1 && len(r.grid.selected) <= 4 {
+		l := make([]int, 0, len(r.grid.selected))
+		for i := range r.grid.selected {
+			l = append(l, i)
+		}
+		sort.Ints(l)
+		r.startCompare(l)
+		return
+	}
+	r.startCompare(r.following(2))
+}
+
+// following returns the current image and the n-1 visible ones after it,
+// or before it near the end.
+func (r *Rater) following(n int) []int {
+	vis := r.visible()
+	from := position(vis, r.index)
+	if from > len(vis)-n {
+		from = len(vis) - n
+	}
+	if from < 0 {
+		from = 0
+	}
+	l := make([]int, 0, n)
+	for p := from; p < len(vis) && len(l) < n; p++ {
+		l = append(l, vis[p])
+	}
+	return l
+}
+
+func (r *Rater) startCompare(l []int) {
+	if len(l) < 2 {
+		r.compare.enabled = false
+		fmt.Println("nothing to compare with")
+		return
+	}
+
+	r.compare.enabled = true
+	r.compare.files = l
+	r.compare.zoom, r.compare.cx, r.compare.cy = 1, 0.5, 0.5
+	r.compare.actual = false
+	if p := position(l, r.index); p >= len(l) || l[p] != r.index {
+		r.index = l[0]
+	}
+}
+
+// compareLayout returns the amount of columns and rows of panes.
+func (r *Rater) compareLayout() (cols, rows int) {
+	if len(r.compare.files) == 4 {
+		return 2, 2
+	}
+	return len(r.compare.files), 1
+}
+
+func (r *Rater) compareActive() int {
+	for n, i := range r.compare.files {
+		if i == r.index {
+			return n
+		}
+	}
+	return 0
+}
+
+func (r *Rater) setCompareActive(n int) {
+	l := len(r.compare.files)
+	r.index = r.compare.files[((n%l)+l)%l]
+}
+
+func (r *Rater) zoomCompare(factor float64) {
+	r.compare.zoom *= factor
+	if r.compare.zoom < 1 {
+		r.compare.zoom = 1
+	}
+	if r.compare.zoom > compareMaxZoom {
+		r.compare.zoom = compareMaxZoom
+	}
+	r.panCompare(0, 0)
+}
+
+// panCompare moves the view dx and dy pane sizes.
+func (r *Rater) panCompare(dx, dy float64) {
+	clamp := func(v float64) float64 {
+		half := 0.5 / r.compare.zoom
+		return math.Max(half, math.Min(1-half, v))
+	}
+	r.compare.cx = clamp(r.compare.cx + dx/r.compare.zoom)
+	r.compare.cy = clamp(r.compare.cy + dy/r.compare.zoom)
+}
+
+// promote keeps the current image and gives it the highest rating of the
+// compared images (at least 1). The others are trashed or, without trash,
+// rated one lower.
+func (r *Rater) promote(trash bool) {
+	metas := make([]meta.Meta, len(r.compare.files))
+	var best uint8 = 1
+	for n, i := range r.compare.files {
+		r.updateMeta(r.files[i], func(m *meta.Meta) (bool, error) {
+			metas[n] = *m
+			return false, nil
+		})
+		if metas[n].Rating > best {
+			best = metas[n].Rating
+		}
+	}
+
+	for n, i := range r.compare.files {
+		upd := update{-1, -1}
+		switch {
+		case i == r.index:
+			upd = update{int(best), 0}
+		case trash:
+			upd.Deleted = 1
+		case metas[n].Rating > 0:
+			upd.Rating = int(metas[n].Rating) - 1
+		}
+		r.applyUpdate(r.files[i], upd)
+	}
+}
+
+func (r *Rater) onKeyCompare(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+	const pan = 0.1
+	switch key {
+	case glfw.KeyQ:
+		r.window.SetShouldClose(true)
+	case glfw.KeyF:
+		r.toggleFS()
+	case glfw.KeyC, glfw.KeyEscape:
+		r.toggleCompare()
+	case glfw.KeyN:
+		n := 4
+		if len(r.compare.files) > 2 {
+			n = 2
+		}
+		r.startCompare(r.following(n))
+	case glfw.KeyV:
+		r.toggleEdited()
+	case glfw.KeyP:
+		// print uses r.index; restore it afterwards.
+		r.clear()
+		active := r.index
+		for _, i := range r.compare.files {
+			r.index = i
+			r.print(r.file())
+		}
+		r.index = active
+		return
+
+	case glfw.KeyTab:
+		d := 1
+		if mods&glfw.ModShift != 0 {
+			d = -1
+		}
+		r.setCompareActive(r.compareActive() + d)
+
+	case glfw.KeyEqual, glfw.KeyKPAdd:
+		r.zoomCompare(1.25)
+	case glfw.KeyMinus, glfw.KeyKPSubtract:
+		r.zoomCompare(1 / 1.25)
+	case glfw.KeyZ:
+		if r.compare.zoom > 1 {
+			r.compare.zoom, r.compare.cx, r.compare.cy = 1, 0.5, 0.5
+			break
+		}
+		r.compare.actual = true
+	case glfw.KeyLeft:
+		r.panCompare(-pan, 0)
+	case glfw.KeyRight:
+		r.panCompare(pan, 0)
+	case glfw.KeyUp:
+		r.panCompare(0, -pan)
+	case glfw.KeyDown:
+		r.panCompare(0, pan)
+
+	case glfw.KeyK:
+		r.promote(true)
+	case glfw.KeyJ:
+		r.promote(false)
+
+	default:
+		upd := keyUpdate(key)
+		if upd.Rating < 0 && upd.Deleted < 0 {
+			return
+		}
+		r.applyUpdate(r.file(), upd)
+	}
+
+	r.main()
+}
+
+func (r *Rater) onScroll(w *glfw.Window, xoff, yoff float64) {
+	if !r.compare.enabled || yoff == 0 {
+		return
+	}
+	r.zoomCompare(math.Pow(1.25, yoff))
+}
+
+func (r *Rater) onMouseButton(w *glfw.Window, button glfw.MouseButton, action glfw.Action, mods glfw.ModifierKey) {
+	if button != glfw.MouseButtonLeft {
+		return
+	}
+	r.compare.drag = r.compare.enabled && action == glfw.Press
+	r.compare.dragX, r.compare.dragY = w.GetCursorPos()
+}
+
+func (r *Rater) onCursorPos(w *glfw.Window, x, y float64) {
+	if !r.compare.drag || !r.compare.enabled {
+		return
+	}
+	cols, rows := r.compareLayout()
+	ww, wh := w.GetSize()
+	pw, ph := float64(ww)/float64(cols), float64(wh)/float64(rows)
+	r.panCompare((r.compare.dragX-x)/pw, (r.compare.dragY-y)/ph)
+	r.compare.dragX, r.compare.dragY = x, y
+}
+
+func (r *Rater) compareUsage() {
+	fmt.Printf(`
+%s%sCOMPARE%s
+c | esc      : back
+n            : toggle between comparing 2 and 4 images, starting at the current one
+tab          : next image (shift+tab: previous)
++ | - | wheel: zoom all images
+z            : toggle between fit and 1:1
+arrows | drag: pan all images
+
+the keys below apply to the current image (highlighted):
+k            : keep, give it the highest rating of the compared images and trash the others
+j            : keep, give it the highest rating of the compared images and rate the others one lower
+1-5          : rate 1-5
+0            : remove rating
+d | delete   : delete
+u            : undelete
+
+p            : print the filename and meta of all compared images
+v            : toggle between the original and edited preview
+
+q            : quit
+f            : toggle fullscreen
+`, r.term.clrBlue, r.term.clrBlueContrast, r.term.none)
+}
+
+// full opens the largest preview of the file at index.
+func (r *Rater) full(index int) (io.ReadCloser, error) {
+	f := r.files[index]
+	if r.edited {
+		rc, err := r.compl.imp.GetEditedPreview(f, importer.PreviewFull)
+		if err == nil {
+			return rc, nil
+		}
+		if !errors.Is(err, importer.ErrNotEdited) {
+			log.Printf("WARN no preview of the current edit of %s (see -action previews -edited-previews): %s", f.Path(), err)
+		}
+	}
+	return r.compl.imp.GetPreview(f, importer.PreviewFull)
+}
+
+// compareView draws the compared images in panes with the same zoom and
+// pan.
+type compareView struct {
+	*texCache
+	vao    uint32
+	border uint32
+}
+
+func newCompareView(r *Rater, ebo uint32) *compareView {
+	return &compareView{
+		texCache: newTexCache(r.full),
+		vao:      unitQuad(ebo),
+		border:   solidTexture(color.RGBA{255, 255, 255, 255}),
+	}
+}
+
+func (c *compareView) draw(r *Rater, modelUniform int32) error {
+	cols, rows := r.compareLayout()
+	pw, ph := float64(r.realWidth)/float64(cols), float64(r.realHeight)/float64(rows)
+	active := r.compareActive()
+
+	if r.compare.actual {
+		r.compare.actual = false
+		if _, dim := c.texture(r, r.index); dim.X > 0 && dim.Y > 0 {
+			r.zoomCompare(1 / math.Min(pw/float64(dim.X), ph/float64(dim.Y)))
+		}
+	}
+
+	gl.BindVertexArray(c.vao)
+	gl.Enable(gl.SCISSOR_TEST)
+	for n, index := range r.compare.files {
+		px, py := float64(n%cols)*pw, float64(n/cols)*ph
+		// scissor coordinates start at the bottom left.
+		gl.Scissor(int32(px), int32(float64(r.realHeight)-py-ph), int32(math.Ceil(pw)), int32(math.Ceil(ph)))
+
+		tex, dim := c.texture(r, index)
+		if tex != 0 && dim.X > 0 && dim.Y > 0 {
+			scale := math.Min(pw/float64(dim.X), ph/float64(dim.Y)) * r.compare.zoom
+			w, h := float64(dim.X)*scale, float64(dim.Y)*scale
+			x := px + pw/2 - r.compare.cx*w
+			y := py + ph/2 - r.compare.cy*h
+			drawQuad(modelUniform, tex-1, float32(x), float32(y), float32(w), float32(h))
+		}
+
+		if n == active {
+			b := float32(compareBorder)
+			x, y, w, h := float32(px), float32(py), float32(pw), float32(ph)
+			drawQuad(modelUniform, c.border, x, y, w, b)
+			drawQuad(modelUniform, c.border, x, y+h-b, w, b)
+			drawQuad(modelUniform, c.border, x, y, b, h)
+			drawQuad(modelUniform, c.border, x+w-b, y, b, h)
+		}
+	}
+	gl.Disable(gl.SCISSOR_TEST)
+	gl.BindVertexArray(0)
+
+	c.release(func(index int) bool {
+		for _, i := range r.compare.files {
+			if i == index {
+				return true
+			}
+		}
+		return false
+	})
+
+	return nil
+}
+
+// reset releases all textures, the full previews are too large to keep
+// around once done comparing.
+func (c *compareView) reset() {
+	c.release(func(int) bool { return false })
+}
@@ -0,0 +1,441 @@
+//go:build !nogl
+
+package rate
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/frizinak/photos/importer"
+	"github.com/go-gl/gl/v4.6-core/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+const (
+	gridDefaultCols = 6
+	gridMaxCols     = 20
+	gridPad         = 8
+	gridBorder      = 3
+)
+
+type gridState struct {
+	enabled  bool
+	cols     int
+	selected map[int]bool
+	// anchor is the file index a shift+move range starts at, -1 if none.
+	anchor int
+}
+
+func (r *Rater) toggleGrid() {
+	r.grid.enabled = !r.grid.enabled
+	if r.grid.selected == nil {
+		r.grid.selected = make(map[int]bool)
+		r.grid.anchor = -1
+	}
+	if r.grid.cols == 0 {
+		r.grid.cols = gridDefaultCols
+	}
+}
+
+// gridSize returns the amount of columns and rows, rows are derived from the
+// window size so cells are roughly 4:3.
+func (r *Rater) gridSize() (cols, rows int) {
+	cols = r.grid.cols
+	if cols < 1 {
+		cols = gridDefaultCols
+	}
+	rows = 1
+	if r.realWidth > 0 {
+		rows = int(math.Round(float64(cols) * float64(r.realHeight) / float64(r.realWidth) * 4 / 3))
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	return
+}
+
+// visible returns the indices of the files that are not collapsed into a
+// stack.
+func (r *Rater) visible() []int {
+	l := make([]int, 0, len(r.files))
+	for i := range r.files {
+		if !r.hidden(i) {
+			l = append(l, i)
+		}
+	}
+	return l
+}
+
+func position(vis []int, index int) int {
+	return sort.SearchInts(vis, index)
+}
+
+// targets returns the files actions apply to: the selection in the grid view
+// or the current file.
+func (r *Rater) targets() []*importer.File {
+	if !r.grid.enabled || len(r.grid.selected) == 0 {
+		return []*importer.File{r.file()}
+	}
+
+	ix := make([]int, 0, len(r.grid.selected))
+	for i := range r.grid.selected {
+		ix = append(ix, i)
+	}
+	sort.Ints(ix)
+	l := make([]*importer.File, len(ix))
+	for n, i := range ix {
+		l[n] = r.files[i]
+	}
+	return l
+}
+
+// moveGrid moves the cursor delta visible files, with extend the files
+// between the anchor and the new cursor are selected.
+func (r *Rater) moveGrid(delta int, extend bool) {
+	vis := r.visible()
+	if len(vis) == 0 {
+		return
+	}
+	n := position(vis, r.index) + delta
+	if n < 0 {
+		n = 0
+	}
+	if n >= len(vis) {
+		n = len(vis) - 1
+	}
+
+	if !extend {
+		r.grid.anchor = -1
+		r.index = vis[n]
+		return
+	}
+
+	if r.grid.anchor < 0 {
+		r.grid.anchor = r.index
+	}
+	r.index = vis[n]
+	from, to := position(vis, r.grid.anchor), n
+	if from > to {
+		from, to = to, from
+	}
+	for _, i := range vis[from : to+1] {
+		r.grid.selected[i] = true
+	}
+}
+
+func (r *Rater) toggleSelected() {
+	if r.grid.selected[r.index] {
+		delete(r.grid.selected, r.index)
+	} else {
+		r.grid.selected[r.index] = true
+	}
+	r.grid.anchor = r.index
+}
+
+func (r *Rater) onKeyGrid(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+	extend := mods&glfw.ModShift != 0
+	cols, rows := r.gridSize()
+	doprint := true
+
+	switch key {
+	case glfw.KeyQ:
+		r.window.SetShouldClose(true)
+	case glfw.KeyF:
+		r.toggleFS()
+	case glfw.KeyG, glfw.KeyEnter, glfw.KeyKPEnter:
+		r.toggleGrid()
+	case glfw.KeyT:
+		r.toggleTagging()
+	case glfw.KeyE:
+		doprint = r.startEditing()
+	case glfw.KeyV:
+		r.toggleEdited()
+	case glfw.KeyS:
+		r.toggleStack()
+	case glfw.KeyC:
+		r.toggleCompare()
+
+	case glfw.KeyLeft:
+		r.moveGrid(-1, extend)
+	case glfw.KeyRight:
+		r.moveGrid(1, extend)
+	case glfw.KeyUp:
+		r.moveGrid(-cols, extend)
+	case glfw.KeyDown:
+		r.moveGrid(cols, extend)
+	case glfw.KeyPageUp:
+		r.moveGrid(-cols*rows, extend)
+	case glfw.KeyPageDown:
+		r.moveGrid(cols*rows, extend)
+	case glfw.KeyHome:
+		r.moveGrid(-len(r.files), extend)
+	case glfw.KeyEnd:
+		r.moveGrid(len(r.files), extend)
+
+	case glfw.KeySpace:
+		r.toggleSelected()
+	case glfw.KeyEscape:
+		r.grid.selected = make(map[int]bool)
+		r.grid.anchor = -1
+
+	case glfw.KeyEqual, glfw.KeyKPAdd:
+		if r.grid.cols > 1 {
+			r.grid.cols--
+		}
+	case glfw.KeyMinus, glfw.KeyKPSubtract:
+		if r.grid.cols < gridMaxCols {
+			r.grid.cols++
+		}
+
+	default:
+		upd := keyUpdate(key)
+		if upd.Rating < 0 && upd.Deleted < 0 {
+			return
+		}
+		targets := r.targets()
+		for _, f := range targets {
+			r.applyUpdate(f, upd)
+		}
+		if len(targets) == 1 && len(r.grid.selected) == 0 && (upd.Rating > 0 || upd.Deleted == 1) {
+			r.nextIfAuto()
+		}
+	}
+
+	if doprint {
+		r.main()
+	}
+}
+
+func (r *Rater) gridUsage() {
+	fmt.Printf(`
+%s%sGRID%s %d selected
+enter | g    : back to the single image view
+arrows       : move
+shift+arrows : select a range
+space        : toggle the selection of the current image
+esc          : clear the selection
+page up/down : previous / next page
++ | -        : larger / smaller thumbnails
+c            : compare the selection (2-4 images) or the current and next image
+
+the keys below apply to the selection, or the current image if none:
+1-5          : rate 1-5
+0            : remove rating
+d | delete   : delete
+u            : undelete
+t            : enter tagging mode
+e            : edit
+
+q            : quit
+f            : toggle fullscreen
+v            : toggle between the original and edited preview
+s            : expand or collapse the burst or bracket of the current image
+`, r.term.clrBlue, r.term.clrBlueContrast, r.term.none, len(r.grid.selected))
+}
+
+// thumb opens the thumbnail of the file at index.
+func (r *Rater) thumb(index int) (io.ReadCloser, error) {
+	f := r.files[index]
+	if r.edited {
+		rc, err := r.compl.imp.GetEditedPreview(f, importer.PreviewThumb)
+		if err == nil || !errors.Is(err, importer.ErrNotEdited) {
+			return rc, err
+		}
+	}
+	return r.compl.imp.GetPreview(f, importer.PreviewThumb)
+}
+
+// texRetry is how long to wait before loading an image that failed to load
+// again, e.g.: its preview might be generated in the meantime.
+const texRetry = 2 * time.Second
+
+// texCache holds the textures of files, loaded with open.
+type texCache struct {
+	open func(index int) (io.ReadCloser, error)
+	// textures maps file indices to texture+1.
+	textures map[int]uint32
+	dims     map[int]image.Point
+	// failed holds when images last failed to load.
+	failed map[int]time.Time
+	edited bool
+}
+
+func newTexCache(open func(index int) (io.ReadCloser, error)) *texCache {
+	return &texCache{
+		open:     open,
+		textures: make(map[int]uint32),
+		dims:     make(map[int]image.Point),
+		failed:   make(map[int]time.Time),
+	}
+}
+
+func (c *texCache) texture(r *Rater, index int) (uint32, image.Point) {
+	if c.edited != r.edited {
+		c.edited = r.edited
+		c.release(func(int) bool { return false })
+	}
+	if tex, ok := c.textures[index]; ok {
+		return tex, c.dims[index]
+	}
+	last, failed := c.failed[index]
+	if failed && time.Since(last) < texRetry {
+		return 0, image.Point{}
+	}
+
+	fail := func(msg string, err error) (uint32, image.Point) {
+		// only warn once, not on every retry.
+		if !failed {
+			log.Printf("WARN %s for %s: %s", msg, r.files[index].Path(), err)
+		}
+		c.failed[index] = time.Now()
+		return 0, image.Point{}
+	}
+
+	f, err := c.open(index)
+	if err != nil {
+		return fail("could not get preview", err)
+	}
+	img, _, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return fail("could not decode preview", err)
+	}
+	delete(c.failed, index)
+
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	c.textures[index] = imgTexture(rgba) + 1
+	c.dims[index] = image.Pt(bounds.Dx(), bounds.Dy())
+	return c.textures[index], c.dims[index]
+}
+
+// release deletes the textures of files for which keep returns false.
+func (c *texCache) release(keep func(index int) bool) {
+	for i, tex := range c.textures {
+		if keep(i) {
+			continue
+		}
+		releaseTexture(tex - 1)
+		delete(c.textures, i)
+		delete(c.dims, i)
+	}
+	for i := range c.failed {
+		if !keep(i) {
+			delete(c.failed, i)
+		}
+	}
+}
+
+func solidTexture(c color.RGBA) uint32 {
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.SetRGBA(0, 0, c)
+	return imgTexture(img)
+}
+
+// unitQuad creates a vao of a 1x1 quad, scaled and translated with the model
+// uniform by drawQuad.
+func unitQuad(ebo uint32) uint32 {
+	d := points{}
+	buf(&d, 0, 0, 1, 1)
+	var vao, vbo uint32
+	gl.GenVertexArrays(1, &vao)
+	gl.GenBuffers(1, &vbo)
+	gl.BindVertexArray(vao)
+	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, ebo)
+	gl.BindBuffer(gl.ARRAY_BUFFER, vbo)
+	gl.BufferData(gl.ARRAY_BUFFER, stride*vertices*fs, gl.Ptr(&d[0]), gl.STATIC_DRAW)
+	gl.EnableVertexAttribArray(0)
+	gl.VertexAttribPointer(0, 2, gl.FLOAT, false, stride*fs, gl.PtrOffset(0))
+	gl.EnableVertexAttribArray(1)
+	gl.VertexAttribPointer(1, 2, gl.FLOAT, false, stride*fs, gl.PtrOffset(2*fs))
+	gl.BindBuffer(gl.ARRAY_BUFFER, 0)
+	gl.BindVertexArray(0)
+	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, 0)
+	return vao
+}
+
+func drawQuad(modelUniform int32, tex uint32, x, y, w, h float32) {
+	m := mgl32.Translate3D(x, y, 0).Mul4(mgl32.Scale3D(w, h, 1))
+	gl.UniformMatrix4fv(modelUniform, 1, false, &m[0])
+	gl.BindTexture(gl.TEXTURE_2D, tex)
+	gl.DrawElements(gl.TRIANGLES, 6, gl.UNSIGNED_INT, gl.PtrOffset(0))
+}
+
+// gridView draws pages of thumbnails.
+type gridView struct {
+	*texCache
+	vao uint32
+
+	cursor, selected, missing uint32
+}
+
+func newGridView(r *Rater, ebo uint32) *gridView {
+	return &gridView{
+		texCache: newTexCache(r.thumb),
+		vao:      unitQuad(ebo),
+		cursor:   solidTexture(color.RGBA{255, 255, 255, 255}),
+		selected: solidTexture(color.RGBA{80, 40, 220, 255}),
+		missing:  solidTexture(color.RGBA{40, 40, 40, 255}),
+	}
+}
+
+func (g *gridView) draw(r *Rater, modelUniform int32) error {
+	cols, rows := r.gridSize()
+	per := cols * rows
+	vis := r.visible()
+	page := position(vis, r.index) / per
+	cw, ch := float32(r.realWidth)/float32(cols), float32(r.realHeight)/float32(rows)
+
+	quad := func(tex uint32, x, y, w, h float32) { drawQuad(modelUniform, tex, x, y, w, h) }
+
+	gl.BindVertexArray(g.vao)
+	for n := 0; n < per; n++ {
+		p := page*per + n
+		if p >= len(vis) {
+			break
+		}
+		index := vis[p]
+		tex, dim := g.texture(r, index)
+
+		w, h := cw-2*gridPad, ch-2*gridPad
+		if tex != 0 && dim.X > 0 && dim.Y > 0 {
+			scale := float32(math.Min(float64(w)/float64(dim.X), float64(h)/float64(dim.Y)))
+			w, h = float32(dim.X)*scale, float32(dim.Y)*scale
+		}
+		x := float32(n%cols)*cw + (cw-w)/2
+		y := float32(n/cols)*ch + (ch-h)/2
+
+		b := float32(gridBorder)
+		if r.grid.selected[index] {
+			quad(g.selected, x-2*b, y-2*b, w+4*b, h+4*b)
+		}
+		if index == r.index {
+			quad(g.cursor, x-b, y-b, w+2*b, h+2*b)
+		}
+		if tex == 0 {
+			quad(g.missing, x, y, w, h)
+			continue
+		}
+		quad(tex-1, x, y, w, h)
+	}
+	gl.BindVertexArray(0)
+
+	// keep the adjacent pages around.
+	from, to := (page-1)*per, (page+2)*per
+	g.release(func(index int) bool {
+		p := position(vis, index)
+		return p < len(vis) && vis[p] == index && p >= from && p < to
+	})
+
+	return nil
+}
@@ -12,10 +12,12 @@ import (
 
 type Rater struct{}
 
-var err = errors.New("rater can't be launched as no GL backend available")
+var err = errors.New("rater can't be launched as no GL backend available, use -action serve instead")
 
-func New(log *log.Logger, files []*importer.File, imp *importer.Importer) (*Rater, error) {
+func New(log *log.Logger, files []*importer.File, imp *importer.Importer, editor func(file string) error) (*Rater, error) {
 	return nil, err
 }
 
-func (r Rater) Run() error { return err }
+func (r Rater) Run() error                  { return err }
+func (r Rater) Add(files ...*importer.File) {}
+func (r Rater) Follow(follow bool)          {}
@@ -17,6 +17,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/frizinak/photos/importer"
 	"github.com/frizinak/photos/meta"
@@ -92,6 +93,9 @@ type Rater struct {
 	invert        bool
 	tagging       bool
 	preview       bool
+	edited        bool
+	grid          gridState
+	compare       compareState
 	editingList   []string
 	editingChoice []rune
 	editor        func(file string) error
@@ -124,13 +128,32 @@ type Rater struct {
 		}
 	}
 
+	stacks struct {
+		entries  []stackEntry
+		picked   map[string]bool
+		expanded map[string]bool
+	}
+
+	follow  bool
+	pending struct {
+		sync.Mutex
+		files []*importer.File
+	}
+
 	files []*importer.File
 	log   *log.Logger
 }
 
 func New(log *log.Logger, files []*importer.File, imp *importer.Importer, editor func(file string) error) (*Rater, error) {
+	files, err := imp.CollapsePairs(files)
+	if err != nil {
+		return nil, err
+	}
 	r := &Rater{files: files, log: log, editor: editor}
 	r.compl.imp = imp
+	if err := r.addStacks(files); err != nil {
+		return nil, err
+	}
 
 	r.term.clrRed = "\033[48;5;124m"
 	r.term.clrRedContrast = "\033[38;5;231m"
@@ -152,6 +175,49 @@ func New(log *log.Logger, files []*importer.File, imp *importer.Importer, editor
 	return r, nil
 }
 
+// Add appends files while the rater is running, safe for concurrent use.
+func (r *Rater) Add(files ...*importer.File) {
+	r.pending.Lock()
+	r.pending.files = append(r.pending.files, files...)
+	r.pending.Unlock()
+}
+
+// Follow makes the rater jump to each file passed to Add.
+func (r *Rater) Follow(follow bool) { r.follow = follow }
+
+func (r *Rater) takePending() []*importer.File {
+	r.pending.Lock()
+	l := r.pending.files
+	r.pending.files = nil
+	r.pending.Unlock()
+	return l
+}
+
+// collapse drops JPEGs from l of which the RAW is shown as well.
+func (r *Rater) collapse(l []*importer.File) []*importer.File {
+	if len(l) == 0 {
+		return l
+	}
+	all := make([]*importer.File, 0, len(r.files)+len(l))
+	all = append(append(all, r.files...), l...)
+	keep, err := r.compl.imp.CollapsePairs(all)
+	if err != nil {
+		r.log.Println(err)
+		return l
+	}
+	m := make(map[*importer.File]struct{}, len(keep))
+	for _, f := range keep {
+		m[f] = struct{}{}
+	}
+	n := make([]*importer.File, 0, len(l))
+	for _, f := range l {
+		if _, ok := m[f]; ok {
+			n = append(n, f)
+		}
+	}
+	return n
+}
+
 func (r *Rater) addCompletion(str ...string) {
 	r.initCompletion()
 	r.compl.list = append(r.compl.list, str...).Unique()
@@ -178,7 +244,7 @@ func (r *Rater) initCompletion() {
 	if r.compl.list == nil {
 		r.compl.list = make(meta.Tags, 0)
 		err := r.compl.imp.All(func(f *importer.File) (bool, error) {
-			m, err := importer.GetMeta(f)
+			m, err := r.compl.imp.Meta(f)
 			if err != nil {
 				return true, nil
 			}
@@ -197,6 +263,14 @@ func (r *Rater) main() {
 	r.text = false
 	r.clear()
 	r.print(r.file())
+	if r.compare.enabled {
+		r.compareUsage()
+		return
+	}
+	if r.grid.enabled {
+		r.gridUsage()
+		return
+	}
 	r.usage()
 }
 
@@ -234,6 +308,10 @@ func (r *Rater) onKey(w *glfw.Window, key glfw.Key, scancode int, action glfw.Ac
 		r.onKeyTagging(w, key, scancode, action, mods)
 	case len(r.editingList) != 0:
 		r.onKeyEditing(w, key, scancode, action, mods)
+	case r.compare.enabled && action != glfw.Release:
+		r.onKeyCompare(w, key, scancode, action, mods)
+	case r.grid.enabled && action != glfw.Release:
+		r.onKeyGrid(w, key, scancode, action, mods)
 	case action != glfw.Release:
 		r.onKeyMain(w, key, scancode, action, mods)
 	}
@@ -356,13 +434,15 @@ q | esc      : cancel
 			last = m.Tags
 			return false, nil
 		})
-		r.updateMeta(file, func(m *meta.Meta) (bool, error) {
-			for _, t := range last {
-				m.Tags = append(m.Tags, t)
-				r.addCompletion(t)
-			}
-			return true, nil
-		})
+		for _, f := range r.targets() {
+			r.updateMeta(f, func(m *meta.Meta) (bool, error) {
+				for _, t := range last {
+					m.Tags = append(m.Tags, t)
+					r.addCompletion(t)
+				}
+				return true, nil
+			})
+		}
 		help(file)
 
 	case glfw.KeyA:
@@ -372,11 +452,13 @@ q | esc      : cancel
 			if len(tags) == 0 {
 				return nil
 			}
-			r.updateMeta(file, func(m *meta.Meta) (bool, error) {
-				m.Tags = append(m.Tags, tags...)
-				r.addCompletion(tags...)
-				return true, nil
-			})
+			for _, f := range r.targets() {
+				r.updateMeta(f, func(m *meta.Meta) (bool, error) {
+					m.Tags = append(m.Tags, tags...)
+					r.addCompletion(tags...)
+					return true, nil
+				})
+			}
 			return nil
 		}
 		fmt.Print("add tag: ")
@@ -389,11 +471,13 @@ q | esc      : cancel
 		})
 		r.inputCB = func(input []rune) error {
 			tags := commaSep(string(input))
-			r.updateMeta(file, func(m *meta.Meta) (bool, error) {
-				m.Tags = tags
-				r.addCompletion(tags...)
-				return true, nil
-			})
+			for _, f := range r.targets() {
+				r.updateMeta(f, func(m *meta.Meta) (bool, error) {
+					m.Tags = tags
+					r.addCompletion(tags...)
+					return true, nil
+				})
+			}
 			return nil
 		}
 		fmt.Print("tags: ", string(r.input))
@@ -411,26 +495,28 @@ q | esc      : cancel
 		}
 		r.inputCB = func(input []rune) error {
 			strs := commaSep(string(input))
-			ints := make(map[int]struct{}, len(strs))
+			del := make(map[string]struct{}, len(strs))
 			for i := range strs {
 				n, err := strconv.Atoi(strs[i])
-				if err != nil {
+				if err != nil || n < 1 || n > len(met.Tags) {
 					return errors.New("invalid input")
 				}
-				ints[n-1] = struct{}{}
+				del[met.Tags[n-1]] = struct{}{}
 			}
 
-			r.updateMeta(file, func(m *meta.Meta) (bool, error) {
-				tags := make(meta.Tags, 0, len(m.Tags))
-				for i := range m.Tags {
-					if _, ok := ints[i]; !ok {
-						tags = append(tags, m.Tags[i])
+			for _, f := range r.targets() {
+				r.updateMeta(f, func(m *meta.Meta) (bool, error) {
+					tags := make(meta.Tags, 0, len(m.Tags))
+					for i := range m.Tags {
+						if _, ok := del[m.Tags[i]]; !ok {
+							tags = append(tags, m.Tags[i])
+						}
 					}
-				}
-				m.Tags = tags
-				r.addCompletion(tags...)
-				return true, nil
-			})
+					m.Tags = tags
+					r.addCompletion(tags...)
+					return true, nil
+				})
+			}
 			return nil
 		}
 		fmt.Print("delete tag: ")
@@ -539,27 +625,7 @@ func (r *Rater) onKeyMain(w *glfw.Window, key glfw.Key, scancode int, action glf
 		r.addIndex(1)
 
 	case glfw.KeyE:
-		f := r.file()
-		doprint = true
-		fmt.Printf(
-			"\n%s%s assembling files %s\n",
-			r.term.clrBlue,
-			r.term.clrBlueContrast,
-			r.term.none,
-		)
-		l, _ := r.compl.imp.FindLinks(f)
-		if len(l) == 0 {
-			doprint = false
-			fmt.Printf(
-				"\n%s%s no files available for editing %s\n",
-				r.term.clrRed,
-				r.term.clrRedContrast,
-				r.term.none,
-			)
-			break
-		}
-
-		r.editingList = l
+		doprint = r.startEditing()
 	case glfw.KeyA:
 		r.auto = !r.auto
 		enabled := "enabled"
@@ -571,50 +637,46 @@ func (r *Rater) onKeyMain(w *glfw.Window, key glfw.Key, scancode int, action glf
 	case glfw.KeyI:
 		r.invert = !r.invert
 
-	case glfw.KeyD, glfw.KeyDelete:
-		upd.Deleted = 1
-		r.nextIfAuto()
-	case glfw.KeyU:
-		upd.Deleted = 0
-
-	case glfw.Key0:
-		upd.Rating = 0
-	case glfw.Key1:
-		upd.Rating = 1
-		r.nextIfAuto()
-	case glfw.Key2:
-		upd.Rating = 2
-		r.nextIfAuto()
-	case glfw.Key3:
-		upd.Rating = 3
-		r.nextIfAuto()
-	case glfw.Key4:
-		upd.Rating = 4
-		r.nextIfAuto()
-	case glfw.Key5:
-		upd.Rating = 5
-		r.nextIfAuto()
-
 	case glfw.KeyP:
 		doprint = true
 
 	case glfw.KeyO:
 		r.preview = !r.preview
+
+	case glfw.KeyV:
+		r.toggleEdited()
+
+	case glfw.KeyL:
+		r.follow = !r.follow
+		enabled := "enabled"
+		if !r.follow {
+			enabled = "disabled"
+		}
+		fmt.Printf("following new images %s\n", enabled)
+	case glfw.KeyEnd:
+		r.setIndex(len(r.files) - 1)
+	case glfw.KeyS:
+		r.toggleStack()
+		doprint = true
+	case glfw.KeyG:
+		r.toggleGrid()
+		doprint = true
+	case glfw.KeyC:
+		r.toggleCompare()
+		doprint = true
+
+	default:
+		upd = keyUpdate(key)
+		if upd.Rating > 0 || upd.Deleted == 1 {
+			r.nextIfAuto()
+		}
 	}
 
 	doprint = doprint || li != r.index
 
 	if upd.Rating > -1 || upd.Deleted > -1 {
 		changed = true
-		r.updateMeta(r.getFile(li), func(m *meta.Meta) (bool, error) {
-			if upd.Deleted > -1 {
-				m.Deleted = upd.Deleted == 1
-			}
-			if upd.Rating > -1 {
-				m.Rating = uint8(upd.Rating)
-			}
-			return true, nil
-		})
+		r.applyUpdate(r.getFile(li), upd)
 	}
 
 	if changed || doprint {
@@ -622,6 +684,68 @@ func (r *Rater) onKeyMain(w *glfw.Window, key glfw.Key, scancode int, action glf
 	}
 }
 
+// startEditing assembles the links of the targets (see targets) to choose
+// from in the editing menu.
+func (r *Rater) startEditing() bool {
+	fmt.Printf(
+		"\n%s%s assembling files %s\n",
+		r.term.clrBlue,
+		r.term.clrBlueContrast,
+		r.term.none,
+	)
+	var l []string
+	for _, f := range r.targets() {
+		links, _ := r.compl.imp.FindLinks(f)
+		l = append(l, links...)
+	}
+	if len(l) == 0 {
+		fmt.Printf(
+			"\n%s%s no files available for editing %s\n",
+			r.term.clrRed,
+			r.term.clrRedContrast,
+			r.term.none,
+		)
+		return false
+	}
+
+	r.editingList = l
+	return true
+}
+
+func (r *Rater) toggleEdited() {
+	r.edited = !r.edited
+	showing := "edited"
+	if !r.edited {
+		showing = "original"
+	}
+	fmt.Printf("showing %s previews\n", showing)
+}
+
+func keyUpdate(key glfw.Key) update {
+	upd := update{-1, -1}
+	switch key {
+	case glfw.KeyD, glfw.KeyDelete:
+		upd.Deleted = 1
+	case glfw.KeyU:
+		upd.Deleted = 0
+	case glfw.Key0, glfw.Key1, glfw.Key2, glfw.Key3, glfw.Key4, glfw.Key5:
+		upd.Rating = int(key - glfw.Key0)
+	}
+	return upd
+}
+
+func (r *Rater) applyUpdate(f *importer.File, upd update) {
+	r.updateMeta(f, func(m *meta.Meta) (bool, error) {
+		if upd.Deleted > -1 {
+			m.Deleted = upd.Deleted == 1
+		}
+		if upd.Rating > -1 {
+			m.Rating = uint8(upd.Rating)
+		}
+		return true, nil
+	})
+}
+
 func (r *Rater) file() *importer.File {
 	return r.files[r.index]
 }
@@ -636,7 +760,22 @@ func (r *Rater) nextIfAuto() {
 	}
 }
 func (r *Rater) addIndex(i int) {
-	r.setIndex(r.index + i)
+	step := 1
+	if i < 0 {
+		step, i = -1, -i
+	}
+	ix := r.index
+	for ; i > 0; i-- {
+		n := ix + step
+		for n >= 0 && n < len(r.files) && r.hidden(n) {
+			n += step
+		}
+		if n < 0 || n >= len(r.files) {
+			break
+		}
+		ix = n
+	}
+	r.setIndex(ix)
 }
 
 func (r *Rater) setIndex(i int) {
@@ -646,6 +785,22 @@ func (r *Rater) setIndex(i int) {
 	} else if r.index >= len(r.files) {
 		r.index = len(r.files) - 1
 	}
+
+	if !r.hidden(r.index) {
+		return
+	}
+	for n := r.index - 1; n >= 0; n-- {
+		if !r.hidden(n) {
+			r.index = n
+			return
+		}
+	}
+	for n := r.index + 1; n < len(r.files); n++ {
+		if !r.hidden(n) {
+			r.index = n
+			return
+		}
+	}
 }
 
 func (r *Rater) onResize(wnd *glfw.Window, width, height int) {
@@ -685,6 +840,9 @@ f            : toggle fullscreen
 z            : toggle zoom
 i            : invert image
 o            : toggle between preview and converted image
+v            : toggle between the original and edited preview
+g            : grid view
+c            : compare with the next image (n in compare: 4 images)
 
 a            : toggle automatically go to next image after deleting or rating
 e            : edit the current image with phodo
@@ -700,6 +858,9 @@ t            : enter tagging mode
 
 left | space : next
 right        : previous
+end          : last
+l            : toggle jumping to new images as they arrive (tether)
+s            : expand or collapse the burst or bracket of the current image
 `, r.term.clrBlue, r.term.clrBlueContrast, r.term.none)
 }
 
@@ -727,6 +888,10 @@ func (r *Rater) updateMeta(f *importer.File, mod func(*meta.Meta) (save bool, er
 
 	if err := importer.SaveMeta(f, *rm); err != nil {
 		r.fatal(err)
+		return
+	}
+	if _, err := importer.SyncPair(f, *rm); err != nil {
+		r.fatal(err)
 	}
 }
 
@@ -780,6 +945,13 @@ func (r *Rater) print(f *importer.File) {
 	}
 
 	fmt.Printf("%s %s%s %d/5 \033[0m\n", delString, color, colorContrast, met.Rating)
+	if met.Stack != "" {
+		pick := ""
+		if met.Pick {
+			pick = ", pick"
+		}
+		fmt.Printf("stack of %d%s\n", r.stackSize(met.Stack), pick)
+	}
 }
 
 func (r *Rater) Run() error {
@@ -803,7 +975,7 @@ func (r *Rater) Run() error {
 	r.invalidateVAOs = make([]bool, len(r.files))
 	r.fullscreen = false
 	r.proj = mgl32.Ortho2D(0, 800, 800, 0)
-	r.index = 0
+	r.setIndex(0)
 
 	r.clear()
 	r.print(r.file())
@@ -817,6 +989,9 @@ func (r *Rater) Run() error {
 	r.window.SetPosCallback(r.onPos)
 	r.window.SetKeyCallback(r.onKey)
 	r.window.SetCharCallback(r.onText)
+	r.window.SetScrollCallback(r.onScroll)
+	r.window.SetMouseButtonCallback(r.onMouseButton)
+	r.window.SetCursorPosCallback(r.onCursorPos)
 	w, h := r.window.GetFramebufferSize()
 	r.onResize(r.window, w, h)
 
@@ -841,6 +1016,7 @@ func (r *Rater) Run() error {
 	lastI := -1
 	invert := r.invert
 	preview := r.preview
+	edited := r.edited
 	var lastTex uint32 = 0
 
 	modelUniform := gl.GetUniformLocation(program, gl.Str("model\x00"))
@@ -921,7 +1097,17 @@ func (r *Rater) Run() error {
 		return vaos[index], dims
 	}
 
-	getImage := func(preview bool) (f io.ReadCloser, err error) {
+	getImage := func(preview, edited bool) (f io.ReadCloser, err error) {
+		if edited {
+			f, err = r.compl.imp.GetEditedPreview(r.file(), importer.PreviewScreen)
+			if err == nil {
+				return
+			}
+			if !errors.Is(err, importer.ErrNotEdited) {
+				log.Printf("WARN no preview of the current edit of %s (see -action previews -edited-previews): %s", r.file().Path(), err)
+			}
+		}
+
 		if !preview {
 			rf := r.file()
 			if m, e := importer.GetMeta(rf); e == nil {
@@ -947,12 +1133,12 @@ func (r *Rater) Run() error {
 			}
 		}
 
-		return importer.GetPreview(r.file())
+		return r.compl.imp.GetPreview(r.file(), importer.PreviewScreen)
 	}
 
 	update := func() error {
 		vao, dimension = getVAO(r.index)
-		if r.index == lastI && r.invert == invert && r.preview == preview {
+		if r.index == lastI && r.invert == invert && r.preview == preview && r.edited == edited {
 			return nil
 		}
 
@@ -965,8 +1151,8 @@ func (r *Rater) Run() error {
 			invert = r.invert
 		}
 
-		if r.preview != preview {
-			preview = r.preview
+		if r.preview != preview || r.edited != edited {
+			preview, edited = r.preview, r.edited
 			for i := range textures {
 				vaos[i] = 0
 				vbos[i] = 0
@@ -985,7 +1171,7 @@ func (r *Rater) Run() error {
 			return nil
 		}
 
-		f, err := getImage(r.preview)
+		f, err := getImage(r.preview, r.edited)
 		if err != nil {
 			log.Printf("WARN could not get preview for %s: %s", r.file().Path(), err)
 			tex = 0
@@ -1024,8 +1210,31 @@ func (r *Rater) Run() error {
 		return nil
 	}
 
+	grid := newGridView(r, ebo)
+	cmp := newCompareView(r, ebo)
+
 	var lastDim image.Point
 	frame := func() error {
+		if r.compare.enabled {
+			if r.proj != lastProjection {
+				gl.UniformMatrix4fv(projectionUniform, 1, false, &r.proj[0])
+				lastProjection = r.proj
+			}
+			lastTex, lastDim = 0, image.Point{}
+			return cmp.draw(r, modelUniform)
+		}
+		cmp.reset()
+
+		if r.grid.enabled {
+			if r.proj != lastProjection {
+				gl.UniformMatrix4fv(projectionUniform, 1, false, &r.proj[0])
+				lastProjection = r.proj
+			}
+			// rebind and recenter once back in the single image view.
+			lastTex, lastDim = 0, image.Point{}
+			return grid.draw(r, modelUniform)
+		}
+
 		if err = update(); err != nil {
 			return err
 		}
@@ -1062,6 +1271,26 @@ func (r *Rater) Run() error {
 	}
 
 	for !r.window.ShouldClose() {
+		if l := r.collapse(r.takePending()); len(l) != 0 {
+			if err := r.addStacks(l); err != nil {
+				return err
+			}
+			r.files = append(r.files, l...)
+			n := len(l)
+			textures = append(textures, make([]uint32, n)...)
+			vaos = append(vaos, make([]uint32, n)...)
+			vbos = append(vbos, make([]uint32, n)...)
+			dimensions = append(dimensions, make([]image.Point, n)...)
+			r.invalidateVAOs = append(r.invalidateVAOs, make([]bool, n)...)
+			// don't pull the rug from under tagging or editing.
+			if !r.tagging && len(r.editingList) == 0 {
+				if r.follow {
+					r.setIndex(len(r.files) - 1)
+				}
+				r.main()
+			}
+		}
+
 		gl.Clear(gl.COLOR_BUFFER_BIT)
 		if err = frame(); err != nil {
 			return err
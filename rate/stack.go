@@ -0,0 +1,73 @@
+//go:build !nogl
+
+package rate
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/frizinak/photos/importer"
+)
+
+type stackEntry struct {
+	id   string
+	pick bool
+}
+
+// addStacks loads the stack of each file, must be called for every file
+// appended to r.files.
+func (r *Rater) addStacks(files []*importer.File) error {
+	if r.stacks.picked == nil {
+		r.stacks.picked = make(map[string]bool)
+		r.stacks.expanded = make(map[string]bool)
+	}
+	for _, f := range files {
+		m, err := r.compl.imp.Meta(f)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		r.stacks.entries = append(r.stacks.entries, stackEntry{m.Stack, m.Pick})
+		if m.Stack != "" && m.Pick {
+			r.stacks.picked[m.Stack] = true
+		}
+	}
+	return nil
+}
+
+// hidden reports whether the file at index is collapsed into the pick of
+// its stack.
+func (r *Rater) hidden(index int) bool {
+	e := r.stacks.entries[index]
+	return e.id != "" && !e.pick && r.stacks.picked[e.id] && !r.stacks.expanded[e.id]
+}
+
+func (r *Rater) stackSize(id string) int {
+	n := 0
+	for _, e := range r.stacks.entries {
+		if e.id == id {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Rater) toggleStack() {
+	e := r.stacks.entries[r.index]
+	if e.id == "" {
+		fmt.Println("not part of a stack")
+		return
+	}
+
+	r.stacks.expanded[e.id] = !r.stacks.expanded[e.id]
+	state := "expanded"
+	if !r.stacks.expanded[e.id] {
+		state = "collapsed"
+		for i, s := range r.stacks.entries {
+			if s.id == e.id && s.pick {
+				r.index = i
+				break
+			}
+		}
+	}
+	fmt.Printf("stack of %d %s\n", r.stackSize(e.id), state)
+}
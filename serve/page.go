@@ -0,0 +1,197 @@
+package serve
+
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>photos</title>
+<style>
+html, body { margin: 0; height: 100%; background: #000; color: #ddd; font-family: sans-serif; }
+#img { position: absolute; top: 0; left: 0; right: 0; bottom: 3em; display: flex; align-items: center; justify-content: center; }
+#img img { max-width: 100%; max-height: 100%; }
+#img.deleted img { opacity: 0.3; }
+#bar { position: absolute; left: 0; right: 0; bottom: 0; height: 3em; display: flex; align-items: center; gap: 0.5em; padding: 0 0.5em; background: #111; font-size: 0.9em; }
+#bar button { background: #222; color: #ddd; border: 1px solid #444; padding: 0.4em 0.7em; }
+#bar .grow { flex: 1; overflow: hidden; white-space: nowrap; text-overflow: ellipsis; }
+#info { position: absolute; top: 0; left: 0; padding: 0.5em; background: rgba(0, 0, 0, 0.6); white-space: pre; display: none; }
+#info.show { display: block; }
+#tag { position: absolute; left: 0.5em; bottom: 3.5em; display: none; }
+#tag.show { display: block; }
+#tag input { font-size: 1.2em; width: 20em; }
+.rating { color: #fc0; }
+.trash { color: #f44; }
+#help { position: absolute; right: 0; top: 0; padding: 0.5em; background: rgba(0, 0, 0, 0.8); white-space: pre; display: none; }
+#help.show { display: block; }
+</style>
+</head>
+<body>
+<div id="img"><img id="preview" alt=""></div>
+<div id="info"></div>
+<div id="help">left/right/space  previous/next
+0-5               rate
+d/delete          trash
+u                 untrash
+a                 toggle auto advance
+t                 add tags
+m                 modify tags
+i                 toggle info
+?                 toggle help</div>
+<form id="tag"><input id="tagInput" list="tags" autocomplete="off"><datalist id="tags"></datalist></form>
+<div id="bar">
+<button data-key="ArrowLeft">&lt;</button>
+<button data-key="0">0</button><button data-key="1">1</button><button data-key="2">2</button>
+<button data-key="3">3</button><button data-key="4">4</button><button data-key="5">5</button>
+<button data-key="d">trash</button><button data-key="u">untrash</button>
+<button data-key="t">tag</button>
+<span class="grow" id="status"></span>
+<button data-key="?">?</button>
+<button data-key="ArrowRight">&gt;</button>
+</div>
+<script>
+(function () {
+    var files = [], index = 0, auto = false, tagMode = null;
+    var $ = function (id) { return document.getElementById(id); };
+
+    function api(method, path, body) {
+        return fetch(path, {
+            method: method,
+            headers: body ? {'Content-Type': 'application/json'} : {},
+            body: body ? JSON.stringify(body) : undefined,
+        }).then(function (r) {
+            if (!r.ok) {
+                return r.text().then(function (t) { throw new Error(t); });
+            }
+            return r.json();
+        });
+    }
+
+    function loadTags() {
+        api('GET', '/api/tags').then(function (tags) {
+            var dl = $('tags');
+            dl.innerHTML = '';
+            tags.forEach(function (t) {
+                var o = document.createElement('option');
+                o.value = t;
+                dl.appendChild(o);
+            });
+        });
+    }
+
+    function render() {
+        var f = files[index];
+        if (!f) {
+            $('status').textContent = 'no files';
+            return;
+        }
+        $('preview').src = '/preview/' + f.index;
+        $('img').className = f.deleted ? 'deleted' : '';
+        var s = (index + 1) + '/' + files.length + ' ' + f.name + ' ';
+        $('status').innerHTML = '';
+        $('status').appendChild(document.createTextNode(s));
+        var r = document.createElement('span');
+        r.className = f.deleted ? 'trash' : 'rating';
+        r.textContent = f.deleted ? 'TRASH' : '★'.repeat(f.rating);
+        $('status').appendChild(r);
+        if (f.tags.length) {
+            $('status').appendChild(document.createTextNode(' [' + f.tags.join(', ') + ']'));
+        }
+        if (auto) {
+            $('status').appendChild(document.createTextNode(' (auto)'));
+        }
+        $('info').textContent = [
+            f.name, f.created, f.device, f.exposure, f.location,
+        ].filter(function (v) { return v; }).join('\n');
+
+        [index + 1, index - 1].forEach(function (n) {
+            if (files[n]) {
+                new Image().src = '/preview/' + files[n].index;
+            }
+        });
+    }
+
+    function go(n) {
+        index = Math.max(0, Math.min(files.length - 1, index + n));
+        render();
+    }
+
+    function update(upd, advance) {
+        var f = files[index];
+        api('POST', '/api/file/' + f.index, upd).then(function (nf) {
+            files[files.indexOf(f)] = nf;
+            render();
+        }).catch(function (e) { alert(e.message); });
+        if (advance && auto) {
+            go(1);
+        }
+    }
+
+    function tag(mode) {
+        tagMode = mode;
+        $('tagInput').value = mode === 'modify' ? files[index].tags.join(',') : '';
+        $('tag').className = 'show';
+        $('tagInput').focus();
+    }
+
+    $('tag').addEventListener('submit', function (e) {
+        e.preventDefault();
+        var tags = $('tagInput').value.split(',').map(function (t) { return t.trim(); }).filter(function (t) { return t; });
+        if (tagMode === 'modify') {
+            update({tags: tags});
+        } else if (tags.length) {
+            update({addTags: tags});
+        }
+        $('tag').className = '';
+        $('tagInput').blur();
+        loadTags();
+    });
+
+    $('tagInput').addEventListener('keydown', function (e) {
+        if (e.key === 'Escape') {
+            $('tag').className = '';
+            $('tagInput').blur();
+        }
+        e.stopPropagation();
+    });
+
+    function key(k) {
+        switch (k) {
+        case 'ArrowLeft': case '[': go(-1); break;
+        case 'ArrowRight': case ']': case ' ': go(1); break;
+        case '0': update({rating: 0}); break;
+        case '1': case '2': case '3': case '4': case '5': update({rating: parseInt(k, 10)}, true); break;
+        case 'd': case 'Delete': update({deleted: true}, true); break;
+        case 'u': update({deleted: false}); break;
+        case 'a': auto = !auto; render(); break;
+        case 't': tag('add'); break;
+        case 'm': tag('modify'); break;
+        case 'i': $('info').classList.toggle('show'); break;
+        case '?': $('help').classList.toggle('show'); break;
+        default: return false;
+        }
+        return true;
+    }
+
+    document.addEventListener('keydown', function (e) {
+        if (e.ctrlKey || e.altKey || e.metaKey) {
+            return;
+        }
+        if (key(e.key)) {
+            e.preventDefault();
+        }
+    });
+
+    Array.prototype.forEach.call(document.querySelectorAll('#bar button'), function (b) {
+        b.addEventListener('click', function () { key(b.getAttribute('data-key')); b.blur(); });
+    });
+
+    api('GET', '/api/files').then(function (l) {
+        files = l;
+        render();
+    }).catch(function (e) { $('status').textContent = e.message; });
+    loadTags();
+})();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,346 @@
+package serve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/frizinak/photos/importer"
+	"github.com/frizinak/photos/meta"
+)
+
+type Server struct {
+	log   *log.Logger
+	imp   *importer.Importer
+	files []*importer.File
+
+	// addr is the address the server listens on, requests changing meta
+	// should be addressed to it.
+	addr string
+
+	sem  sync.Mutex
+	tags map[string]struct{}
+}
+
+func New(log *log.Logger, files []*importer.File, imp *importer.Importer) (*Server, error) {
+	files, err := imp.CollapsePairs(files)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{log: log, imp: imp, files: files}, nil
+}
+
+type file struct {
+	Index    int      `json:"index"`
+	Name     string   `json:"name"`
+	Created  string   `json:"created"`
+	Rating   uint8    `json:"rating"`
+	Deleted  bool     `json:"deleted"`
+	Tags     []string `json:"tags"`
+	Location string   `json:"location,omitempty"`
+	Device   string   `json:"device,omitempty"`
+	Exposure string   `json:"exposure,omitempty"`
+}
+
+type update struct {
+	Rating  *int      `json:"rating"`
+	Deleted *bool     `json:"deleted"`
+	Tags    *[]string `json:"tags"`
+	AddTags []string  `json:"addTags"`
+}
+
+func (s *Server) toFile(n int, m meta.Meta) file {
+	f := file{
+		Index:   n,
+		Name:    s.files[n].BaseFilename(),
+		Created: m.CreatedTime().Format(time.RFC3339),
+		Rating:  m.Rating,
+		Deleted: m.Deleted,
+		Tags:    m.Tags.Unique(),
+	}
+	if f.Tags == nil {
+		f.Tags = []string{}
+	}
+	if l := m.Location; l != nil {
+		f.Location = l.Name
+		if f.Location == "" {
+			f.Location = fmt.Sprintf("%f,%f", l.Lat, l.Lng)
+		}
+	}
+	if c := m.CameraInfo; c != nil {
+		f.Device = c.DeviceString()
+		f.Exposure = c.ExposureString()
+	}
+	return f
+}
+
+// updateMeta behaves like the rater: the meta is only written if mod
+// returns true.
+func (s *Server) updateMeta(f *importer.File, mod func(*meta.Meta) (save bool, err error)) (meta.Meta, error) {
+	s.sem.Lock()
+	defer s.sem.Unlock()
+	m, err := importer.EnsureMeta(f)
+	if err != nil {
+		return m, err
+	}
+
+	if save, err := mod(&m); !save || err != nil {
+		return m, err
+	}
+
+	for _, t := range m.Tags {
+		s.tags[t] = struct{}{}
+	}
+
+	if err := importer.SaveMeta(f, m); err != nil {
+		return m, err
+	}
+	_, err = importer.SyncPair(f, m)
+	return m, err
+}
+
+func (s *Server) initTags() error {
+	s.tags = make(map[string]struct{})
+	return s.imp.All(func(f *importer.File) (bool, error) {
+		m, err := s.imp.Meta(f)
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, t := range m.Tags {
+			s.tags[t] = struct{}{}
+		}
+		return true, nil
+	})
+}
+
+func (s *Server) json(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.log.Println(err)
+	}
+}
+
+func (s *Server) error(w http.ResponseWriter, code int, err error) {
+	if code == http.StatusInternalServerError {
+		s.log.Println(err)
+	}
+	http.Error(w, err.Error(), code)
+}
+
+func (s *Server) index(r *http.Request, prefix string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, prefix))
+	if err != nil || n < 0 || n >= len(s.files) {
+		return 0, errors.New("no such file")
+	}
+	return n, nil
+}
+
+// sameOrigin returns an error unless the request is addressed to the listen
+// address and originates from a page served by it. Cross-site requests could
+// otherwise trash files of whoever has the page open.
+func (s *Server) sameOrigin(r *http.Request) error {
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/json" {
+		return errors.New("content-type should be application/json")
+	}
+
+	if s.addr != "" && !s.validHost(r.Host) {
+		return fmt.Errorf("invalid host %s", r.Host)
+	}
+
+	if o := r.Header.Get("Origin"); o != "" {
+		u, err := url.Parse(o)
+		if err != nil || u.Host != r.Host {
+			return fmt.Errorf("invalid origin %s", o)
+		}
+	}
+	return nil
+}
+
+func (s *Server) validHost(host string) bool {
+	lhost, lport, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return false
+	}
+	h, port, err := net.SplitHostPort(host)
+	if err != nil || port != lport {
+		return false
+	}
+
+	loopback := func(host string) bool {
+		if host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && ip.IsLoopback()
+	}
+
+	switch ip := net.ParseIP(lhost); {
+	case lhost == "" || (ip != nil && ip.IsUnspecified()):
+		return true
+	case loopback(lhost):
+		return loopback(h)
+	}
+	return h == lhost
+}
+
+func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	list := make([]file, len(s.files))
+	for n, f := range s.files {
+		m, err := s.imp.Meta(f)
+		if err != nil {
+			s.error(w, http.StatusInternalServerError, err)
+			return
+		}
+		list[n] = s.toFile(n, m)
+	}
+
+	s.json(w, list)
+}
+
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	n, err := s.index(r, "/api/file/")
+	if err != nil {
+		s.error(w, http.StatusNotFound, err)
+		return
+	}
+
+	var upd update
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := s.sameOrigin(r); err != nil {
+			s.error(w, http.StatusForbidden, err)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&upd); err != nil {
+			s.error(w, http.StatusBadRequest, err)
+			return
+		}
+		if upd.Rating != nil && (*upd.Rating < 0 || *upd.Rating > 5) {
+			s.error(w, http.StatusBadRequest, errors.New("rating should be between 0 and 5"))
+			return
+		}
+	default:
+		s.error(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	f := s.files[n]
+	m, err := s.updateMeta(f, func(m *meta.Meta) (bool, error) {
+		save := false
+		if upd.Rating != nil {
+			m.Rating = uint8(*upd.Rating)
+			save = true
+		}
+		if upd.Deleted != nil {
+			m.Deleted = *upd.Deleted
+			save = true
+		}
+		if upd.Tags != nil {
+			m.Tags = *upd.Tags
+			save = true
+		}
+		if len(upd.AddTags) != 0 {
+			m.Tags = append(m.Tags, upd.AddTags...)
+			save = true
+		}
+		return save, nil
+	})
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.json(w, s.toFile(n, m))
+}
+
+func (s *Server) handleTags(w http.ResponseWriter, r *http.Request) {
+	s.sem.Lock()
+	tags := make(meta.Tags, 0, len(s.tags))
+	for t := range s.tags {
+		tags = append(tags, t)
+	}
+	s.sem.Unlock()
+
+	s.json(w, tags.Unique())
+}
+
+func (s *Server) handlePreview(w http.ResponseWriter, r *http.Request) {
+	n, err := s.index(r, "/preview/")
+	if err != nil {
+		s.error(w, http.StatusNotFound, err)
+		return
+	}
+
+	f := s.files[n]
+	rc, err := s.imp.GetPreview(f, importer.PreviewScreen)
+	if os.IsNotExist(err) {
+		if _, possible := s.imp.HasPreview(f); !possible {
+			s.error(w, http.StatusNotFound, importer.ErrPreviewNotPossible)
+			return
+		}
+		if err = s.imp.EnsurePreview(f); err == nil {
+			rc, err = s.imp.GetPreview(f, importer.PreviewScreen)
+		}
+	}
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer rc.Close()
+	p, ok := rc.(*os.File)
+	if !ok {
+		s.error(w, http.StatusInternalServerError, errors.New("preview is not a file"))
+		return
+	}
+	st, err := p.Stat()
+	if err != nil {
+		s.error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeContent(w, r, "", st.ModTime(), p)
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, page)
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/api/files", s.handleFiles)
+	mux.HandleFunc("/api/file/", s.handleFile)
+	mux.HandleFunc("/api/tags", s.handleTags)
+	mux.HandleFunc("/preview/", s.handlePreview)
+	return mux
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	if err := s.initTags(); err != nil {
+		return err
+	}
+
+	s.addr = addr
+	s.log.Printf("serving %d files on http://%s", len(s.files), addr)
+	return http.ListenAndServe(addr, s.Handler())
+}
@@ -228,6 +228,18 @@ func (t *Tags) CameraInfo() (CameraInfo, bool) {
 	return c, true
 }
 
+// Orientation returns the exif orientation (1-8), 1 if unknown.
+func (t *Tags) Orientation() int {
+	if t.ex == nil {
+		return 1
+	}
+	v := t.ex.Find(0x0112).Value().Ints()
+	if len(v) == 0 || v[0] < 1 || v[0] > 8 {
+		return 1
+	}
+	return v[0]
+}
+
 func (t *Tags) Bounds() image.Rectangle {
 	if t.ff != nil {
 		return t.ff.Bounds()
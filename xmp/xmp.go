@@ -0,0 +1,559 @@
+package xmp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	NSX         = "adobe:ns:meta/"
+	NSRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	NSXMP       = "http://ns.adobe.com/xap/1.0/"
+	NSDC        = "http://purl.org/dc/elements/1.1/"
+	NSEXIF      = "http://ns.adobe.com/exif/1.0/"
+	NSPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	NSIPTCCore  = "http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/"
+)
+
+var defaultPrefixes = map[string]string{
+	NSX:         "x",
+	NSRDF:       "rdf",
+	NSXMP:       "xmp",
+	NSDC:        "dc",
+	NSEXIF:      "exif",
+	NSPhotoshop: "photoshop",
+	NSIPTCCore:  "Iptc4xmpCore",
+}
+
+const skeleton = "<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n" + `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""/>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`
+
+var dateFormats = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	// exif style, e.g.: exif:DateTimeOriginal written by darktable and exiv2.
+	"2006:01:02 15:04:05",
+}
+
+// node is a minimal xml element that keeps prefixes as they were written so
+// unknown data (e.g.: darktable history) survives a rewrite.
+type node struct {
+	name     xml.Name
+	attr     []xml.Attr
+	children []interface{}
+}
+
+func (n *node) elements() []*node {
+	l := make([]*node, 0, len(n.children))
+	for _, c := range n.children {
+		if e, ok := c.(*node); ok {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+func (n *node) text() string {
+	b := strings.Builder{}
+	for _, c := range n.children {
+		if d, ok := c.(xml.CharData); ok {
+			b.Write(d)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func (n *node) remove(e *node) {
+	for i, c := range n.children {
+		if c == e {
+			n.children = append(n.children[:i], n.children[i+1:]...)
+			return
+		}
+	}
+}
+
+type XMP struct {
+	doc *node
+	ns  map[string]string
+}
+
+func New() *XMP {
+	x, err := Parse(strings.NewReader(skeleton))
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+func Load(path string) (*XMP, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	x, err := Parse(f)
+	if err != nil {
+		err = fmt.Errorf("could not parse xmp '%s': %w", path, err)
+	}
+	return x, err
+}
+
+func Parse(r io.Reader) (*XMP, error) {
+	x := &XMP{doc: &node{}, ns: map[string]string{"xml": "xml"}}
+	dec := xml.NewDecoder(r)
+	stack := []*node{x.doc}
+	for {
+		t, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		cur := stack[len(stack)-1]
+		switch t := t.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name, attr: t.Copy().Attr}
+			for _, a := range n.attr {
+				if a.Name.Space == "xmlns" {
+					x.ns[a.Name.Local] = a.Value
+				}
+			}
+			cur.children = append(cur.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) == 1 {
+				return nil, errors.New("unexpected end element")
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			cur.children = append(cur.children, xml.CopyToken(t))
+		}
+	}
+
+	if len(stack) != 1 {
+		return nil, errors.New("unexpected eof")
+	}
+
+	if len(x.descriptions()) == 0 {
+		return nil, errors.New("no rdf:Description found")
+	}
+
+	return x, nil
+}
+
+func (x *XMP) uri(prefix string) string { return x.ns[prefix] }
+
+func (x *XMP) is(n xml.Name, uri, local string) bool {
+	return n.Local == local && x.uri(n.Space) == uri
+}
+
+func (x *XMP) prefix(uri string) string {
+	for p, u := range x.ns {
+		if u == uri {
+			return p
+		}
+	}
+	return ""
+}
+
+func (x *XMP) walk(n *node, cb func(*node)) {
+	for _, e := range n.elements() {
+		cb(e)
+		x.walk(e, cb)
+	}
+}
+
+func (x *XMP) descriptions() []*node {
+	l := make([]*node, 0, 1)
+	x.walk(x.doc, func(n *node) {
+		if x.is(n.name, NSRDF, "Description") {
+			l = append(l, n)
+		}
+	})
+	return l
+}
+
+// scope returns the first rdf:Description and its ancestors.
+func (x *XMP) scope() []*node {
+	var find func(n *node, path []*node) []*node
+	find = func(n *node, path []*node) []*node {
+		for _, e := range n.elements() {
+			p := append(path, e)
+			if x.is(e.name, NSRDF, "Description") {
+				return p
+			}
+			if l := find(e, p); l != nil {
+				return l
+			}
+		}
+		return nil
+	}
+
+	return find(x.doc, nil)
+}
+
+// name returns the prefixed name for uri:local, declaring the namespace on
+// the first rdf:Description if it is not in scope.
+func (x *XMP) name(uri, local string) xml.Name {
+	scope := x.scope()
+	p := x.prefix(uri)
+	if p != "" {
+		for _, n := range scope {
+			for _, a := range n.attr {
+				if a.Name.Space == "xmlns" && a.Name.Local == p && a.Value == uri {
+					return xml.Name{Space: p, Local: local}
+				}
+			}
+		}
+	}
+
+	if p == "" {
+		p = defaultPrefixes[uri]
+		if p == "" || x.uri(p) != "" {
+			p = fmt.Sprintf("ns%d", len(x.ns))
+		}
+	}
+
+	d := scope[len(scope)-1]
+	d.attr = append(d.attr, xml.Attr{Name: xml.Name{Space: "xmlns", Local: p}, Value: uri})
+	x.ns[p] = uri
+
+	return xml.Name{Space: p, Local: local}
+}
+
+func (x *XMP) Get(uri, local string) (string, bool) {
+	for _, d := range x.descriptions() {
+		for _, a := range d.attr {
+			if x.is(a.Name, uri, local) {
+				return a.Value, true
+			}
+		}
+		for _, e := range d.elements() {
+			if x.is(e.name, uri, local) {
+				return e.text(), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func (x *XMP) Set(uri, local, value string) {
+	for _, d := range x.descriptions() {
+		for i, a := range d.attr {
+			if x.is(a.Name, uri, local) {
+				d.attr[i].Value = value
+				return
+			}
+		}
+		for _, e := range d.elements() {
+			if x.is(e.name, uri, local) {
+				e.children = []interface{}{xml.CharData(value)}
+				return
+			}
+		}
+	}
+
+	d := x.descriptions()[0]
+	d.attr = append(d.attr, xml.Attr{Name: x.name(uri, local), Value: value})
+}
+
+func (x *XMP) Del(uri, local string) {
+	for _, d := range x.descriptions() {
+		attr := make([]xml.Attr, 0, len(d.attr))
+		for _, a := range d.attr {
+			if !x.is(a.Name, uri, local) {
+				attr = append(attr, a)
+			}
+		}
+		d.attr = attr
+		for _, e := range d.elements() {
+			if x.is(e.name, uri, local) {
+				d.remove(e)
+			}
+		}
+	}
+}
+
+func (x *XMP) list(uri, local string) ([]string, bool) {
+	for _, d := range x.descriptions() {
+		for _, e := range d.elements() {
+			if !x.is(e.name, uri, local) {
+				continue
+			}
+			l := make([]string, 0)
+			x.walk(e, func(n *node) {
+				if x.is(n.name, NSRDF, "li") {
+					l = append(l, n.text())
+				}
+			})
+			return l, true
+		}
+	}
+
+	return nil, false
+}
+
+func (x *XMP) setList(uri, local, container string, values []string) {
+	x.Del(uri, local)
+	if len(values) == 0 {
+		return
+	}
+
+	c := &node{name: x.name(NSRDF, container)}
+	for _, v := range values {
+		c.children = append(c.children, &node{
+			name:     x.name(NSRDF, "li"),
+			children: []interface{}{xml.CharData(v)},
+		})
+	}
+
+	d := x.descriptions()[0]
+	d.children = append(d.children, &node{
+		name:     x.name(uri, local),
+		children: []interface{}{c},
+	})
+}
+
+func (x *XMP) Rating() int {
+	v, _ := x.Get(NSXMP, "Rating")
+	r, _ := strconv.Atoi(v)
+	return r
+}
+
+func (x *XMP) SetRating(v int) { x.Set(NSXMP, "Rating", strconv.Itoa(v)) }
+
+// Rejected reports whether the rating is -1, which is how darktable and
+// lightroom mark rejected images.
+func (x *XMP) Rejected() bool { return x.Rating() < 0 }
+
+func (x *XMP) Label() string {
+	v, _ := x.Get(NSXMP, "Label")
+	return v
+}
+
+func (x *XMP) SetLabel(v string) {
+	if v == "" {
+		x.Del(NSXMP, "Label")
+		return
+	}
+	x.Set(NSXMP, "Label", v)
+}
+
+func (x *XMP) Keywords() []string {
+	v, _ := x.list(NSDC, "subject")
+	l := make([]string, 0, len(v))
+	for _, kw := range v {
+		kw = strings.TrimSpace(kw)
+		if kw != "" {
+			l = append(l, kw)
+		}
+	}
+	return l
+}
+
+func (x *XMP) SetKeywords(v []string) { x.setList(NSDC, "subject", "Bag", v) }
+
+func parseCoord(v string) (float64, bool) {
+	v = strings.TrimSpace(v)
+	if len(v) < 2 {
+		return 0, false
+	}
+
+	sign := 1.0
+	switch v[len(v)-1] {
+	case 'S', 's', 'W', 'w':
+		sign = -1
+		v = v[:len(v)-1]
+	case 'N', 'n', 'E', 'e':
+		v = v[:len(v)-1]
+	}
+
+	var c float64
+	div := 1.0
+	for _, p := range strings.Split(v, ",") {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0, false
+		}
+		c += f / div
+		div *= 60
+	}
+
+	return sign * c, true
+}
+
+func formatCoord(v float64, pos, neg byte) string {
+	ref := pos
+	if v < 0 {
+		ref = neg
+		v = -v
+	}
+	d := math.Floor(v)
+	return fmt.Sprintf("%d,%.6f%c", int(d), (v-d)*60, ref)
+}
+
+func (x *XMP) GPS() (lat, lng float64, ok bool) {
+	la, ok1 := x.Get(NSEXIF, "GPSLatitude")
+	ln, ok2 := x.Get(NSEXIF, "GPSLongitude")
+	if !ok1 || !ok2 {
+		return
+	}
+	lat, ok1 = parseCoord(la)
+	lng, ok2 = parseCoord(ln)
+	ok = ok1 && ok2
+	return
+}
+
+func (x *XMP) SetGPS(lat, lng float64) {
+	x.Set(NSEXIF, "GPSLatitude", formatCoord(lat, 'N', 'S'))
+	x.Set(NSEXIF, "GPSLongitude", formatCoord(lng, 'E', 'W'))
+}
+
+func (x *XMP) Created() (time.Time, bool) {
+	for _, p := range [][2]string{
+		{NSEXIF, "DateTimeOriginal"},
+		{NSXMP, "CreateDate"},
+		{NSPhotoshop, "DateCreated"},
+	} {
+		v, ok := x.Get(p[0], p[1])
+		if !ok || v == "" {
+			continue
+		}
+		for _, f := range dateFormats {
+			if t, err := time.ParseInLocation(f, v, time.Local); err == nil {
+				return t, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+func (x *XMP) SetCreated(t time.Time) {
+	x.Set(NSEXIF, "DateTimeOriginal", t.Format("2006-01-02T15:04:05Z07:00"))
+}
+
+// SetLocation stores a human readable location name (Iptc4xmpCore:Location)
+// and address (dc:coverage).
+func (x *XMP) SetLocation(name, address string) {
+	for _, v := range []struct {
+		uri, local, value string
+	}{
+		{NSIPTCCore, "Location", name},
+		{NSDC, "coverage", address},
+	} {
+		if v.value == "" {
+			x.Del(v.uri, v.local)
+			continue
+		}
+		x.Set(v.uri, v.local, v.value)
+	}
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attrEscaper = strings.NewReplacer(
+		"&", "&amp;",
+		"<", "&lt;",
+		">", "&gt;",
+		"\"", "&quot;",
+		"\n", "&#xA;",
+		"\r", "&#xD;",
+		"\t", "&#x9;",
+	)
+)
+
+func (x *XMP) write(w io.Writer, n *node) error {
+	name := func(n xml.Name) string {
+		if n.Space == "" {
+			return n.Local
+		}
+		return n.Space + ":" + n.Local
+	}
+
+	for _, c := range n.children {
+		var err error
+		switch c := c.(type) {
+		case *node:
+			if _, err = fmt.Fprintf(w, "<%s", name(c.name)); err != nil {
+				return err
+			}
+			for _, a := range c.attr {
+				_, err = fmt.Fprintf(w, " %s=\"%s\"", name(a.Name), attrEscaper.Replace(a.Value))
+				if err != nil {
+					return err
+				}
+			}
+			if len(c.children) == 0 {
+				_, err = io.WriteString(w, "/>")
+				break
+			}
+			if _, err = io.WriteString(w, ">"); err != nil {
+				return err
+			}
+			if err = x.write(w, c); err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "</%s>", name(c.name))
+		case xml.CharData:
+			_, err = textEscaper.WriteString(w, string(c))
+		case xml.Comment:
+			_, err = fmt.Fprintf(w, "<!--%s-->", c)
+		case xml.ProcInst:
+			_, err = fmt.Fprintf(w, "<?%s %s?>", c.Target, c.Inst)
+		case xml.Directive:
+			_, err = fmt.Fprintf(w, "<!%s>", c)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (x *XMP) WriteTo(w io.Writer) (int64, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := x.write(buf, x.doc); err != nil {
+		return 0, err
+	}
+	return buf.WriteTo(w)
+}
+
+func (x *XMP) SaveTo(path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	_, err = x.WriteTo(f)
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}